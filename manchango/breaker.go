@@ -0,0 +1,69 @@
+package manchan
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen reports that a CircuitBreakerSender refused a send without
+// trying it, because recent sends kept finding the channel full.
+var ErrCircuitOpen = errors.New("manchan: circuit open")
+
+// CircuitBreakerSender wraps a Sender so that a consumer that keeps falling
+// behind is not hammered with sends; see NewCircuitBreakerSender.
+type CircuitBreakerSender[T any] struct {
+	mu         sync.Mutex
+	tx         *Sender[T]
+	threshold  int
+	cooldown   time.Duration
+	n_failures int
+	opened_at  time.Time
+	is_open    bool
+}
+
+// NewCircuitBreakerSender wraps tx in a circuit breaker. Sends through it
+// never wait: once threshold of them in a row have found the channel full,
+// the circuit opens and every send fails with ErrCircuitOpen, without
+// touching the channel, for cooldown. The first send after that is tried as
+// a probe: if it gets through the circuit closes, and if not it opens again
+// for another cooldown.
+func NewCircuitBreakerSender[T any](tx *Sender[T], threshold int, cooldown time.Duration) *CircuitBreakerSender[T] {
+	return &CircuitBreakerSender[T]{tx: tx, threshold: threshold, cooldown: cooldown}
+}
+
+// Send sends msg if the circuit is closed and the channel has room. It
+// returns ErrCircuitOpen while the circuit is open, ErrFull if the channel
+// was full, and ErrClosed if the sender is closed or the channel has ended.
+func (me *CircuitBreakerSender[T]) Send(msg T) error {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	if me.is_open && time.Since(me.opened_at) < me.cooldown {
+		return ErrCircuitOpen
+	}
+	err := me.tx.Send2(msg, false)
+	switch {
+	case err == nil:
+		me.n_failures = 0
+		me.is_open = false
+	case err == ErrFull:
+		me.n_failures += 1
+		if me.is_open || me.n_failures >= me.threshold {
+			me.is_open = true
+			me.opened_at = time.Now()
+		}
+	}
+	return err
+}
+
+// IsOpen reports whether the circuit is currently refusing sends.
+func (me *CircuitBreakerSender[T]) IsOpen() bool {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	return me.is_open && time.Since(me.opened_at) < me.cooldown
+}
+
+// Close closes the wrapped sender.
+func (me *CircuitBreakerSender[T]) Close() {
+	me.tx.Close()
+}
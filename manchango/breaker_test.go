@@ -0,0 +1,29 @@
+package manchan
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCircuitBreakerSender(t *testing.T) {
+	cooldown := 50 * time.Millisecond
+	tx, rx := NewBoundedChannel[int](1)
+	cb := NewCircuitBreakerSender(tx, 3, cooldown)
+	if err := cb.Send(1); err != nil { t.FailNow() }
+	for i := 0; i < 3; i++ {
+		if err := cb.Send(2); err != ErrFull { t.FailNow() }
+	}
+	if !cb.IsOpen() { t.FailNow() }
+
+	// Open: fails fast even once there is room.
+	rx.Recv()
+	if err := cb.Send(3); err != ErrCircuitOpen { t.FailNow() }
+	if rx.Len() != 0 { t.FailNow() }
+
+	// After the cooldown a probe gets through and closes the circuit.
+	time.Sleep(cooldown)
+	if err := cb.Send(4); err != nil { t.FailNow() }
+	if cb.IsOpen() { t.FailNow() }
+	msg, ok := rx.Recv(); if !ok || msg != 4 { t.FailNow() }
+	cb.Close()
+}
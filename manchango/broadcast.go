@@ -0,0 +1,263 @@
+package manchan
+
+import (
+	"math/rand"
+	"sync"
+)
+
+// hub fans messages out to subscriber channels, one per receiver, so that
+// every Recv variant works unchanged on the receiving side. Senders of a hub
+// share a channel of their own only to count senders; its queue stays empty.
+// Without a route every subscriber gets every message; with one, each
+// message goes only to the subscriber at the index route picks. A fixed hub
+// never drops subscribers, so route always sees the same set; a message
+// routed to one whose receivers have all closed is discarded.
+type hub[T any] struct {
+	sync.Mutex
+	subs        []*Sender[T]
+	replay      []T
+	replay_last int
+	n_sent      int
+	route       func(msg T, n_subs int) int
+	is_fixed    bool
+	is_closed   bool
+	err         error // what the senders failed with, passed on to every subscriber
+}
+
+// NewBroadcastWithReplay returns a broadcast channel: every receiver gets its
+// own copy of each message sent after the receiver was created. A receiver
+// created by Clone first receives up to the replayLast most recent messages,
+// then live ones. Receivers that close are unsubscribed.
+func NewBroadcastWithReplay[T any](replayLast int) (*Sender[T], *Receiver[T]) {
+	h := &hub[T]{replay_last: replayLast}
+	tx, _ := NewChannel[T]()
+	tx.hub = h
+	return tx, h.subscribe(false)
+}
+
+// NewSeededChannel returns a channel that hands each message to one of its
+// receivers chosen by a random generator seeded with seed, instead of to
+// whichever receiver happens to wake first. With the same seed, the same
+// receivers cloned in the same order and the same sequence of sends, every
+// receiver sees the same messages on every run, which makes distribution
+// tests reproducible. A message is decided at send time, so a receiver that
+// stops receiving strands its share rather than leaving it to the others.
+func NewSeededChannel[T any](seed int64) (*Sender[T], *Receiver[T]) {
+	rng := rand.New(rand.NewSource(seed))
+	h := &hub[T]{route: func(_ T, n_subs int) int { return rng.Intn(n_subs) }}
+	tx, _ := NewChannel[T]()
+	tx.hub = h
+	return tx, h.subscribe(false)
+}
+
+// NewPartitionedChannel returns a channel that routes each message to one of
+// partitions receivers, the one at index key(msg) % partitions, so that all
+// messages with the same key arrive on the same receiver, in the order they
+// were sent. Cloning a partition's receiver adds a competing consumer of that
+// partition only. Messages for a partition whose receivers have all closed
+// are discarded. partitions must be positive.
+func NewPartitionedChannel[T any](partitions int, key func(T) uint64) (*Sender[T], []*Receiver[T]) {
+	if partitions < 1 {
+		panic("manchan: partitions must be positive")
+	}
+	h := &hub[T]{
+		route:    func(msg T, n_subs int) int { return int(key(msg) % uint64(n_subs)) },
+		is_fixed: true,
+	}
+	receivers := make([]*Receiver[T], partitions)
+	for i := range receivers {
+		sub, rx := NewChannel[T]()
+		h.subs = append(h.subs, sub)
+		receivers[i] = rx
+	}
+	tx, _ := NewChannel[T]()
+	tx.hub = h
+	return tx, receivers
+}
+
+func (me *hub[T]) subscribe(weak bool) *Receiver[T] {
+	return me.subscribe_from(weak, 0)
+}
+
+// subscribe_from subscribes a receiver whose first message is the one at
+// position from, or the oldest one still kept for replay if that is later.
+func (me *hub[T]) subscribe_from(weak bool, from int) *Receiver[T] {
+	tx, rx := NewChannel[T]()
+	rx.hub = me
+	if weak {
+		rx.is_weak = true
+		rx.shared.inner.n_receivers = 0
+	}
+	me.Lock()
+	defer me.Unlock()
+	skip := min(max(from-(me.n_sent-len(me.replay)), 0), len(me.replay))
+	for _, msg := range me.replay[skip:] {
+		tx.Send(msg)
+	}
+	if me.is_closed {
+		me.close_sub(tx)
+		return rx
+	}
+	me.subs = append(me.subs, tx)
+	return rx
+}
+
+// remember keeps msg for replay to later subscribers.
+func (me *hub[T]) remember(msg T) {
+	me.n_sent += 1
+	if me.replay_last > 0 {
+		if len(me.replay) == me.replay_last {
+			me.replay = me.replay[1:]
+		}
+		me.replay = append(me.replay, msg)
+	}
+}
+
+func (me *hub[T]) send(msg T) {
+	me.Lock()
+	defer me.Unlock()
+	me.send_locked(msg)
+}
+
+func (me *hub[T]) send_locked(msg T) {
+	me.remember(msg)
+	if me.is_fixed {
+		me.subs[me.route(msg, len(me.subs))].send(msg, true)
+		return
+	}
+	if me.route != nil {
+		for len(me.subs) > 0 {
+			i := me.route(msg, len(me.subs))
+			if me.subs[i].send(msg, true) == nil {
+				return
+			}
+			me.subs = append(me.subs[:i], me.subs[i+1:]...)
+		}
+		return
+	}
+	live := me.subs[:0]
+	for _, sub := range me.subs {
+		if sub.send(msg, true) == nil {
+			live = append(live, sub)
+		}
+	}
+	clear(me.subs[len(live):])
+	me.subs = live
+}
+
+// send_all sends msgs in order, taking each subscriber's lock once for the
+// whole batch rather than once per message.
+func (me *hub[T]) send_all(msgs []T) {
+	me.Lock()
+	defer me.Unlock()
+	if me.route != nil {
+		for _, msg := range msgs {
+			me.send_locked(msg)
+		}
+		return
+	}
+	for _, msg := range msgs {
+		me.remember(msg)
+	}
+	live := me.subs[:0]
+	for _, sub := range me.subs {
+		if sub.push_all(msgs) == nil {
+			live = append(live, sub)
+		}
+	}
+	clear(me.subs[len(live):])
+	me.subs = live
+}
+
+// push_all enqueues msgs on a subscriber channel under a single lock
+// acquisition. Subscriber channels are never bounded, so it never waits.
+func (me *Sender[T]) push_all(msgs []T) error {
+	me.shared.inner.Lock()
+	if me.is_closed || me.shared.inner.drops_sends() {
+		me.shared.inner.Unlock()
+		return ErrClosed
+	}
+	for _, msg := range msgs {
+		me.shared.inner.push(me.shared.inner.wrap(msg))
+	}
+	backlog_waiters := me.shared.inner.n_backlog_waiters > 0
+	me.shared.inner.Unlock()
+	me.shared.available.Broadcast()
+	if backlog_waiters {
+		me.shared.grown.Broadcast()
+	}
+	return nil
+}
+
+// BroadcastSlice sends every message in msgs, in order. On a broadcast
+// channel each receiver gets the whole batch, at the cost of one lock
+// acquisition per receiver instead of one per message. On any other channel
+// it is the same as sending each message in turn.
+func (me *Sender[T]) BroadcastSlice(msgs []T) {
+	me.shared.inner.Lock()
+	me.panic_if_moved()
+	if me.is_closed {
+		me.shared.inner.Unlock()
+		panic(err_sender_closed.Error())
+	}
+	me.shared.inner.Unlock()
+	if me.hub == nil {
+		for _, msg := range msgs {
+			me.Send(msg)
+		}
+		return
+	}
+	me.hub.send_all(msgs)
+}
+
+// Position returns how many messages were sent on this receiver's broadcast
+// channel before the next one it will receive, for resuming later with
+// NewBroadcastReceiverAt. It returns -1 for a receiver that is not on a
+// broadcast channel, including a seeded or partitioned one.
+func (me *Receiver[T]) Position() int {
+	if me.hub == nil || me.hub.route != nil {
+		return -1
+	}
+	me.hub.Lock()
+	defer me.hub.Unlock()
+	me.shared.inner.Lock()
+	defer me.shared.inner.Unlock()
+	return me.hub.n_sent - me.shared.inner.length()
+}
+
+// NewBroadcastReceiverAt subscribes a new receiver to rx's broadcast channel,
+// starting at position from as returned by Position. Only messages still kept
+// for replay can be resumed: if from is older than those, the new receiver
+// starts at the oldest one kept. It panics if rx is not on a broadcast
+// channel, seeded and partitioned channels included, or from is negative, as
+// Position reports for such a receiver.
+func NewBroadcastReceiverAt[T any](rx *Receiver[T], from int) *Receiver[T] {
+	if rx.hub == nil || rx.hub.route != nil {
+		panic("manchan: receiver is not on a broadcast channel")
+	}
+	if from < 0 {
+		panic("manchan: position must not be negative")
+	}
+	return rx.hub.subscribe_from(false, from)
+}
+
+// close closes every subscriber, failing each with err if it is not nil, so
+// that Err reports it on every receiver.
+func (me *hub[T]) close(err error) {
+	me.Lock()
+	defer me.Unlock()
+	me.is_closed = true
+	me.err = err
+	for _, sub := range me.subs {
+		me.close_sub(sub)
+	}
+	me.subs = nil
+}
+
+func (me *hub[T]) close_sub(sub *Sender[T]) {
+	if me.err != nil {
+		sub.Fail(me.err)
+	} else {
+		sub.Close()
+	}
+}
@@ -0,0 +1,182 @@
+package manchan
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestBroadcastWithReplay(t *testing.T) {
+	tx, rx := NewBroadcastWithReplay[int](3)
+	for i := 1; i <= 5; i++ {
+		tx.Send(i)
+	}
+	late := rx.Clone()
+	tx.Send(6)
+	tx.Close()
+
+	drain := func(rx *Receiver[int]) []int {
+		results := []int{}
+		for msg, ok := rx.Recv(); ok; msg, ok = rx.Recv() {
+			results = append(results, msg)
+		}
+		return results
+	}
+	if !reflect.DeepEqual(drain(rx), []int{1, 2, 3, 4, 5, 6}) { t.FailNow() }
+	if !reflect.DeepEqual(drain(late), []int{3, 4, 5, 6}) { t.FailNow() }
+	if !reflect.DeepEqual(drain(rx.Clone()), []int{4, 5, 6}) { t.FailNow() }
+}
+
+func TestBroadcastUnsubscribe(t *testing.T) {
+	tx, rx := NewBroadcastWithReplay[int](0)
+	other := rx.Clone()
+	other.Close()
+	tx.Send(1)
+	if len(tx.hub.subs) != 1 { t.FailNow() }
+	msg, ok := rx.Recv(); if !ok || msg != 1 { t.FailNow() }
+}
+
+func TestSeededChannel(t *testing.T) {
+	run := func(seed int64) [3][]int {
+		tx, rx := NewSeededChannel[int](seed)
+		receivers := []*Receiver[int]{rx, rx.Clone(), rx.Clone()}
+
+		var results [3][]int
+		var wg sync.WaitGroup
+		for i, rx := range receivers {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for msg, ok := rx.Recv(); ok; msg, ok = rx.Recv() {
+					results[i] = append(results[i], msg)
+				}
+			}()
+		}
+		for i := 0; i < 30; i++ {
+			tx.Send(i)
+		}
+		tx.Close()
+		wg.Wait()
+		return results
+	}
+
+	first := run(42)
+	if len(first[0])+len(first[1])+len(first[2]) != 30 { t.FailNow() }
+	if !reflect.DeepEqual(first, run(42)) { t.FailNow() }
+	if reflect.DeepEqual(first, run(7)) { t.FailNow() }
+}
+
+func TestBroadcastSlice(t *testing.T) {
+	tx, rx1 := NewBroadcastWithReplay[int](0)
+	rx2 := rx1.Clone()
+	tx.BroadcastSlice([]int{1, 2, 3, 4, 5})
+	tx.Close()
+	for _, rx := range []*Receiver[int]{rx1, rx2} {
+		results := []int{}
+		for msg, ok := rx.Recv(); ok; msg, ok = rx.Recv() {
+			results = append(results, msg)
+		}
+		if !reflect.DeepEqual(results, []int{1, 2, 3, 4, 5}) { t.FailNow() }
+	}
+}
+
+func TestBroadcastPosition(t *testing.T) {
+	tx, rx := NewBroadcastWithReplay[int](5)
+	for i := 1; i <= 5; i++ {
+		tx.Send(i)
+	}
+	for i := 1; i <= 3; i++ {
+		msg, ok := rx.Recv(); if !ok || msg != i { t.FailNow() }
+	}
+	pos := rx.Position()
+	if pos != 3 { t.FailNow() }
+	rx.Close()
+	tx.Close()
+
+	resumed := NewBroadcastReceiverAt(rx, pos)
+	results := []int{}
+	for msg, ok := resumed.Recv(); ok; msg, ok = resumed.Recv() {
+		results = append(results, msg)
+	}
+	if !reflect.DeepEqual(results, []int{4, 5}) { t.FailNow() }
+}
+
+func TestPartitionedChannel(t *testing.T) {
+	tx, receivers := NewPartitionedChannel[[2]int](3, func(msg [2]int) uint64 { return uint64(msg[0]) })
+	for i := 0; i < 30; i++ {
+		tx.Send([2]int{i % 5, i})
+	}
+	tx.Close()
+
+	for p, rx := range receivers {
+		last := map[int]int{}
+		for msg, ok := rx.Recv(); ok; msg, ok = rx.Recv() {
+			if msg[0]%3 != p { t.FailNow() }
+			if prev, seen := last[msg[0]]; seen && msg[1] <= prev { t.FailNow() }
+			last[msg[0]] = msg[1]
+		}
+	}
+}
+
+func TestPartitionedChannelNonPositive(t *testing.T) {
+	for _, partitions := range []int{0, -1} {
+		func() {
+			defer func() {
+				if recover() == nil { t.FailNow() }
+			}()
+			NewPartitionedChannel(partitions, func(i int) uint64 { return uint64(i) })
+		}()
+	}
+}
+
+func TestBroadcastWeakUnsubscribe(t *testing.T) {
+	tx, rx := NewBroadcastWithReplay[int](0)
+	weak := rx.CloneWeak()
+	tx.Send(1)
+	msg, ok := weak.Recv(); if !ok || msg != 1 { t.FailNow() }
+	weak.Close()
+	tx.Send(2)
+	if len(tx.hub.subs) != 1 { t.FailNow() }
+	msg, ok = rx.Recv(); if !ok || msg != 1 { t.FailNow() }
+}
+
+func TestBroadcastReceiverAtInvalid(t *testing.T) {
+	_, rx := NewChannel[int]()
+	if rx.Position() != -1 { t.FailNow() }
+	func() {
+		defer func() {
+			if recover() == nil { t.FailNow() }
+		}()
+		NewBroadcastReceiverAt(rx, 0)
+	}()
+	_, brx := NewBroadcastWithReplay[int](4)
+	func() {
+		defer func() {
+			if recover() == nil { t.FailNow() }
+		}()
+		NewBroadcastReceiverAt(brx, -1)
+	}()
+	_, srx := NewSeededChannel[int](1)
+	if srx.Position() != -1 { t.FailNow() }
+	func() {
+		defer func() {
+			if recover() == nil { t.FailNow() }
+		}()
+		NewBroadcastReceiverAt(srx, 0)
+	}()
+}
+
+func TestBroadcastFail(t *testing.T) {
+	boom := errors.New("boom")
+	tx, rx := NewBroadcastWithReplay[int](1)
+	other := rx.Clone()
+	tx.Send(1)
+	tx.Fail(boom)
+	late := rx.Clone()
+	for _, sub := range []*Receiver[int]{rx, other, late} {
+		msg, ok := sub.Recv(); if !ok || msg != 1 { t.FailNow() }
+		_, ok = sub.Recv(); if ok { t.FailNow() }
+		if sub.Err() != boom { t.FailNow() }
+	}
+}
@@ -0,0 +1,24 @@
+//go:build manchan_debug
+
+package manchan
+
+import "time"
+
+// woken_grace is how long receivers get to wake up after the channel closes
+// before check_woken reports them as lost.
+const woken_grace = time.Second
+
+// check_woken asserts that once the last sender has closed, every receiver
+// blocked on the channel wakes up: after woken_grace, none may still be
+// waiting unless messages remain for it to take. It panics otherwise.
+// Only built with the manchan_debug tag.
+func (me *Shared[T]) check_woken() {
+	go func() {
+		time.Sleep(woken_grace)
+		me.inner.Lock()
+		defer me.inner.Unlock()
+		if me.inner.n_senders == 0 && !me.inner.has_next() && me.inner.n_waiting > 0 {
+			panic("manchan: receivers still blocked after the channel closed")
+		}
+	}()
+}
@@ -0,0 +1,47 @@
+package manchan
+
+import (
+	"bufio"
+	"io"
+)
+
+// FromReader scans r line by line, sending each line without its newline.
+// The channel closes at EOF or on the first read error, which is then
+// reported by the receiver's Err. The channel buffers StageBuffer lines, so
+// scanning waits for a slow consumer, and stops before the next line once
+// every receiver has closed.
+func FromReader(r io.Reader) *Receiver[string] {
+	tx, rx := NewBoundedChannel[string](StageBuffer)
+	go_stage(func() {
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			select {
+			case <-tx.abandoned():
+				tx.Close()
+				return
+			default:
+			}
+			tx.Send(scanner.Text())
+		}
+		if err := scanner.Err(); err != nil {
+			tx.Fail(err)
+			return
+		}
+		tx.Close()
+	})
+	return rx
+}
+
+// ToWriter writes every message received from rx to w, each followed by a
+// newline, until rx closes. It stops at and returns the first write error.
+func ToWriter(rx *Receiver[string], w io.Writer) error {
+	for {
+		msg, ok := rx.Recv()
+		if !ok {
+			return nil
+		}
+		if _, err := io.WriteString(w, msg+"\n"); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package manchan
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFromReader(t *testing.T) {
+	rx := FromReader(strings.NewReader("one\ntwo\nthree\n"))
+	msg, ok := rx.Recv(); if !ok || msg != "one" { t.FailNow() }
+	msg, ok = rx.Recv(); if !ok || msg != "two" { t.FailNow() }
+	msg, ok = rx.Recv(); if !ok || msg != "three" { t.FailNow() }
+	_, ok = rx.Recv(); if ok { t.FailNow() }
+	if rx.Err() != nil { t.FailNow() }
+}
+
+func TestFromReaderError(t *testing.T) {
+	boom := errors.New("boom")
+	rx := FromReader(io.MultiReader(strings.NewReader("one\n"), &failingReader{boom}))
+	msg, ok := rx.Recv(); if !ok || msg != "one" { t.FailNow() }
+	_, ok = rx.Recv(); if ok { t.FailNow() }
+	if rx.Err() != boom { t.FailNow() }
+}
+
+func TestFromReaderBounded(t *testing.T) {
+	rx := FromReader(strings.NewReader(strings.Repeat("line\n", 4*StageBuffer)))
+	time.Sleep(20 * time.Millisecond)
+	if rx.Len() > StageBuffer { t.FailNow() }
+	n := 0
+	for _, ok := rx.Recv(); ok; _, ok = rx.Recv() { n++ }
+	if n != 4*StageBuffer { t.FailNow() }
+}
+
+type failingReader struct {
+	err error
+}
+
+func (me *failingReader) Read([]byte) (int, error) {
+	return 0, me.err
+}
+
+func TestToWriter(t *testing.T) {
+	tx, rx := NewChannel[string]()
+	tx.Send("one")
+	tx.Send("two")
+	tx.Send("three")
+	tx.Close()
+
+	var buf bytes.Buffer
+	if err := ToWriter(rx, &buf); err != nil { t.FailNow() }
+	if buf.String() != "one\ntwo\nthree\n" { t.FailNow() }
+}
+
+func TestToWriterError(t *testing.T) {
+	boom := errors.New("boom")
+	tx, rx := NewChannel[string]()
+	tx.Send("one")
+	tx.Close()
+	if err := ToWriter(rx, &failingWriter{boom}); err != boom { t.FailNow() }
+}
+
+type failingWriter struct {
+	err error
+}
+
+func (me *failingWriter) Write([]byte) (int, error) {
+	return 0, me.err
+}
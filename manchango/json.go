@@ -0,0 +1,32 @@
+package manchan
+
+import "encoding/json"
+
+// DecodeJSON unmarshals each line received from rx into a T. Decoded values
+// are sent on the first returned receiver and decode errors on the second;
+// both close once rx closes, and the stage stops early if the decoded
+// values' receivers all close. The decoded values buffer StageBuffer messages
+// and push back on rx. The errors keep only the latest StageBuffer, dropping
+// older ones, so that leaving them undrained never stalls decoding.
+func DecodeJSON[T any](rx *Receiver[string]) (*Receiver[T], *Receiver[error]) {
+	tx_out, rx_out := NewBoundedChannel[T](StageBuffer)
+	tx_err, rx_err := NewDropOldestChannel[error](StageBuffer, nil)
+	go_stage(func() {
+		defer tx_out.Close()
+		defer tx_err.Close()
+		defer rx.Close()
+		for {
+			line, ok, abandoned := rx.RecvCancel(tx_out.abandoned())
+			if !ok || abandoned {
+				return
+			}
+			var msg T
+			if err := json.Unmarshal([]byte(line), &msg); err != nil {
+				tx_err.Send(err)
+				continue
+			}
+			tx_out.Send(msg)
+		}
+	})
+	return rx_out, rx_err
+}
@@ -0,0 +1,38 @@
+package manchan
+
+import "testing"
+
+func TestDecodeJSON(t *testing.T) {
+	type point struct {
+		X int `json:"x"`
+		Y int `json:"y"`
+	}
+
+	tx, rx := NewChannel[string]()
+	tx.Send(`{"x": 1, "y": 2}`)
+	tx.Send(`{"x": 3,`)
+	tx.Send(`{"x": 5, "y": 6}`)
+	tx.Close()
+
+	points, errs := DecodeJSON[point](rx)
+
+	msg, ok := points.Recv(); if !ok || msg != (point{1, 2}) { t.FailNow() }
+	msg, ok = points.Recv(); if !ok || msg != (point{5, 6}) { t.FailNow() }
+	_, ok = points.Recv(); if ok { t.FailNow() }
+
+	err, ok := errs.Recv(); if !ok || err == nil { t.FailNow() }
+	_, ok = errs.Recv(); if ok { t.FailNow() }
+}
+
+func TestDecodeJSONUndrainedErrors(t *testing.T) {
+	tx, rx := NewChannel[string]()
+	for i := 0; i < 3*StageBuffer; i++ {
+		tx.Send(`{`)
+	}
+	tx.Send(`1`)
+	tx.Close()
+
+	values, errs := DecodeJSON[int](rx)
+	msg, ok := values.Recv(); if !ok || msg != 1 { t.FailNow() }
+	if errs.Len() != StageBuffer { t.FailNow() }
+}
@@ -1,83 +1,2520 @@
 package manchan
 
-import "sync"
+import (
+	"context"
+	"errors"
+	"iter"
+	"reflect"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrClosed reports that a channel closed before an operation could complete.
+var ErrClosed = errors.New("manchan: channel closed")
+
+// ErrCanceled is reported by Err once a channel's cancel function is called.
+var ErrCanceled = errors.New("manchan: channel canceled")
+
+// ErrFull reports that a non-blocking send found a bounded channel full.
+var ErrFull = errors.New("manchan: channel full")
+
+var err_sender_closed = errors.New("Attempt to send on closed sender")
+
+var err_sender_moved = errors.New("Attempt to use moved sender")
+
+var err_too_many_senders = errors.New("Attempt to clone past the sender limit")
+
+var err_too_many_receivers = errors.New("Attempt to clone past the receiver limit")
+
+// overflow is what a bounded channel does with a send that finds it full.
+type overflow int
+
+const (
+	overflow_block overflow = iota
+	overflow_drop_oldest
+	overflow_drop_newest
+)
+
+// item is a queued message. meta is nil unless the channel needs to track
+// something per message.
+type item[T any] struct {
+	msg  T
+	meta *meta
+}
+
+type meta struct {
+	expires  time.Time
+	prio     int
+	size     int
+	consumed chan struct{}
+	sent     time.Time
+}
+
+// Queue is storage for a channel's buffered messages, deciding the order in
+// which they are received; see NewChannelWithQueue. Pop and Peek are only
+// called while Len is positive, and always with the channel's lock held.
+// Remove discards every message for which remove returns true, leaving the
+// rest to be received in the order they would have been, and returns how
+// many it discarded.
+type Queue[T any] interface {
+	Push(msg T)
+	Pop() T
+	Peek() T
+	Len() int
+	Remove(remove func(T) bool) int
+}
 
 type Inner[T any] struct {
 	sync.Mutex
-	queue     []T
-	n_senders uint
+	queue         []item[T]
+	custom        Queue[T]     // if set, messages are stored here instead of queue
+	n_queued      atomic.Int64 // len(queue), readable without the lock
+	n_senders     uint
+	n_receivers   uint
+	n_waiting     uint // receivers blocked in wait_available
+	is_end_seen   bool // a receiver has reported the channel closed
+	max_senders   uint // 0 for no limit; see NewChannelWithMaxClones
+	max_receivers uint
+	is_cut_short  bool
+	is_abandoned  bool
+	abandoned     chan struct{}
+	err           error
+	ttl           time.Duration
+	n_expired     uint64
+	done          chan struct{}
+	deadline      *time.Timer // see NewChannelWithDeadline; stopped once the channel ends
+	prio          func(T) int
+	tier_cap      map[int]int
+	tier_len      map[int]int
+
+	n_backlog_waiters int
+	last_recv         time.Time
+
+	// While any receiver is paused, or gated in RecvWhen, a send wakes every
+	// waiting receiver, since such a one would swallow a single Signal.
+	n_paused int
+	n_gated  int
+
+	// A bounded channel blocks sends while size would exceed max_size. Each
+	// message weighs sizeof(msg), or 1 if sizeof is nil. room is signalled
+	// from release, which is why it lives here rather than on Shared.
+	max_size          int
+	size              int
+	sizeof            func(T) int
+	room              *sync.Cond
+	n_blocked_senders int
+	n_drain_waiters   int
+	n_close_waiters   int
+	n_close_acks      uint // non-weak receivers that have seen the close
+	n_send_blocks     uint64
+	send_blocked_time time.Duration
+	overflow          overflow
+	on_drop           func(T)
+	n_tracked         int
+
+	is_recording  bool
+	history       []T
+	history_limit int
+
+	// In fair mode each send takes a ticket and waits until it is served.
+	is_fair     bool
+	next_ticket uint64
+	serving     uint64
+
+	// watchers are poked, without blocking, whenever a message is pushed or
+	// popped or the channel closes, for waits that span several channels.
+	watchers map[chan struct{}]struct{}
+	// activity is poked the same way, but only on sends.
+	activity []chan struct{}
+
+	// A latency-tracked channel keeps the send-to-receive latency of the last
+	// latency_samples messages received, in a ring.
+	is_latency_tracked bool
+	latencies          []time.Duration
+	next_latency       int
+
+	// An aging priority channel receives whichever message is ahead by less,
+	// except that each second waited counts as age_per_second steps ahead.
+	less           func(a, b T) bool
+	age_per_second float64
+
+	// active_tags holds the tag of every tagged sender that has sent, and
+	// tag_order the same tags in the order they first sent.
+	active_tags map[any]struct{}
+	tag_order   []any
+
+	// With is_instrumented set, Lock counts how often it had to wait.
+	is_instrumented bool
+	n_contentions   uint64
+}
+
+// Lock locks the channel, counting contention if the channel is instrumented.
+// is_instrumented never changes once the channel is shared.
+func (me *Inner[T]) Lock() {
+	if !me.is_instrumented {
+		me.Mutex.Lock()
+		return
+	}
+	if !me.Mutex.TryLock() {
+		me.Mutex.Lock()
+		me.n_contentions += 1
+	}
+}
+
+func (me *Inner[T]) closed() bool {
+	return me.n_senders == 0 || me.drops_sends()
+}
+
+// drops_sends reports whether the channel has ended early, either cut short
+// or abandoned by its receivers, so that sends are silently discarded.
+func (me *Inner[T]) drops_sends() bool {
+	return me.is_cut_short || me.is_abandoned
+}
+
+// mark_done closes done once the channel has closed for good.
+func (me *Inner[T]) mark_done() {
+	select {
+	case <-me.done:
+	default:
+		close(me.done)
+	}
+	me.stop_deadline()
+	me.notify()
+}
+
+// stop_deadline stops the deadline timer, if any, so that it no longer keeps
+// a channel that has ended reachable until the deadline.
+func (me *Inner[T]) stop_deadline() {
+	if me.deadline != nil {
+		me.deadline.Stop()
+		me.deadline = nil
+	}
+}
+
+func (me *Inner[T]) watch(ch chan struct{}) {
+	if me.watchers == nil {
+		me.watchers = map[chan struct{}]struct{}{}
+	}
+	me.watchers[ch] = struct{}{}
+}
+
+func (me *Inner[T]) unwatch(ch chan struct{}) {
+	delete(me.watchers, ch)
+}
+
+// notify pokes every watcher. Watchers are buffered, so a poke that finds one
+// already pending is dropped.
+func (me *Inner[T]) notify() {
+	for ch := range me.watchers {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// push enqueues msg, returning false if it was dropped instead.
+func (me *Inner[T]) push(it item[T]) bool {
+	if me.custom != nil {
+		me.custom.Push(it.msg)
+		me.size += me.weight(it.meta)
+	} else if me.prio != nil {
+		if !me.push_tiered(it) {
+			return false
+		}
+	} else {
+		me.queue = append(me.queue, it)
+		me.size += me.weight(it.meta)
+	}
+	if me.is_recording {
+		me.record(it.msg)
+	}
+	me.n_queued.Add(1)
+	me.notify()
+	for _, ch := range me.activity {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+	return true
+}
+
+func (me *Inner[T]) record(msg T) {
+	if me.history_limit > 0 && len(me.history) == me.history_limit {
+		me.history = me.history[1:]
+	}
+	me.history = append(me.history, msg)
+}
+
+func (me *Inner[T]) wrap(msg T) item[T] {
+	it := item[T]{msg: msg}
+	if me.ttl > 0 || me.prio != nil || me.sizeof != nil || me.is_latency_tracked || me.less != nil {
+		it.meta = &meta{}
+	}
+	if me.is_latency_tracked || me.less != nil {
+		it.meta.sent = time.Now()
+	}
+	if me.ttl > 0 {
+		it.meta.expires = time.Now().Add(me.ttl)
+	}
+	if me.sizeof != nil {
+		it.meta.size = me.sizeof(msg)
+	}
+	return it
+}
+
+func (me *Inner[T]) weight(m *meta) int {
+	if me.sizeof == nil {
+		return 1
+	}
+	return m.size
+}
+
+// full reports whether a bounded channel has no room for it. A message is
+// always let into an empty channel, so one heavier than max_size can still
+// be sent rather than blocking forever.
+func (me *Inner[T]) full(it item[T]) bool {
+	return me.max_size > 0 && me.size > 0 && me.size+me.weight(it.meta) > me.max_size
+}
+
+func (me *Inner[T]) push_tiered(it item[T]) bool {
+	p := me.prio(it.msg)
+	if limit, ok := me.tier_cap[p]; ok && me.tier_len[p] >= limit {
+		return false
+	}
+	it.meta.prio = p
+	me.tier_len[p] += 1
+	me.size += me.weight(it.meta)
+	i := len(me.queue)
+	for i > 0 && me.queue[i-1].meta.prio < p {
+		i--
+	}
+	me.queue = append(me.queue, item[T]{})
+	copy(me.queue[i+1:], me.queue[i:])
+	me.queue[i] = it
+	return true
+}
+
+// unpop puts it back at the front of the queue, to be received next, for
+// rolling back a receive. It ignores bounds and tier caps, since the message
+// already had its place; with tiers it goes to the front of its own tier. It
+// refuses, returning false, once the channel has ended early or a receiver
+// has seen it closed, since no message may follow that.
+func (me *Inner[T]) unpop(it item[T]) bool {
+	if me.drops_sends() || me.is_end_seen {
+		return false
+	}
+	switch {
+	case me.custom != nil:
+		me.custom.Push(it.msg)
+	case me.prio != nil:
+		p := it.meta.prio
+		me.tier_len[p] += 1
+		i := 0
+		for i < len(me.queue) && me.queue[i].meta.prio > p {
+			i++
+		}
+		me.queue = slices.Insert(me.queue, i, it)
+	default:
+		me.queue = slices.Insert(me.queue, 0, it)
+	}
+	me.size += me.weight(it.meta)
+	me.n_queued.Add(1)
+	me.notify()
+	return true
+}
+
+// has_room reports whether it could be sent right now without waiting.
+func (me *Inner[T]) has_room(it item[T]) bool {
+	return !me.full(it) && (!me.is_fair || me.next_ticket == me.serving)
+}
+
+// wait_for_room blocks while a bounded channel has no room for it. In fair
+// mode senders also wait for their turn, so that they get room in the order
+// they arrived rather than whichever wakes first.
+func (me *Inner[T]) wait_for_room(it item[T]) {
+	ticket := me.next_ticket
+	if me.is_fair {
+		me.next_ticket += 1
+	}
+	var blocked_at time.Time
+	for !me.drops_sends() && (me.full(it) || me.is_fair && ticket != me.serving) {
+		if blocked_at.IsZero() {
+			blocked_at = time.Now()
+			me.n_send_blocks += 1
+		}
+		me.n_blocked_senders += 1
+		me.room.Wait()
+		me.n_blocked_senders -= 1
+	}
+	if !blocked_at.IsZero() {
+		me.send_blocked_time += time.Since(blocked_at)
+	}
+	if me.is_fair {
+		me.serving += 1
+		if me.n_blocked_senders > 0 {
+			me.room.Broadcast()
+		}
+	}
+}
+
+// shed makes room for it in a full channel that drops rather than blocks,
+// returning the messages it discarded. A drop-newest channel discards it
+// itself, so the caller must not push it.
+func (me *Inner[T]) shed(it item[T]) []T {
+	var dropped []T
+	if me.overflow == overflow_drop_newest {
+		if me.full(it) {
+			dropped = append(dropped, it.msg)
+		}
+		return dropped
+	}
+	for me.full(it) {
+		dropped = append(dropped, me.queue[0].msg)
+		me.release(me.queue[0].meta)
+		me.queue = me.queue[1:]
+	}
+	return dropped
+}
+
+// release updates the bookkeeping for an item leaving the queue.
+func (me *Inner[T]) release(m *meta) {
+	if me.prio != nil {
+		me.tier_len[m.prio] -= 1
+	}
+	me.size -= me.weight(m)
+	me.n_queued.Add(-1)
+	me.untrack(m)
+	if me.n_blocked_senders > 0 || me.n_drain_waiters > 0 {
+		me.room.Broadcast()
+	}
+	me.notify()
+}
+
+// wait_for_credit blocks while max_in_flight tracked messages are still
+// unconsumed, then takes a credit for one more.
+func (me *Inner[T]) wait_for_credit(max_in_flight int) {
+	for !me.drops_sends() && me.n_tracked >= max_in_flight {
+		me.n_blocked_senders += 1
+		me.room.Wait()
+		me.n_blocked_senders -= 1
+	}
+	me.n_tracked += 1
+}
+
+// untrack returns the credit of a tracked message, closing its consumed
+// channel, once it has left the queue or failed to enter it.
+func (me *Inner[T]) untrack(m *meta) {
+	if m == nil || m.consumed == nil {
+		return
+	}
+	close(m.consumed)
+	m.consumed = nil
+	me.n_tracked -= 1
+	if me.n_blocked_senders > 0 {
+		me.room.Broadcast()
+	}
+}
+
+// remove_where discards every queued message matching pred, keeping the rest
+// in order, and returns how many it discarded.
+func (me *Inner[T]) remove_where(pred func(T) bool) int {
+	if me.custom != nil {
+		n := me.custom.Remove(pred)
+		for i := 0; i < n; i++ {
+			me.release(nil)
+		}
+		return n
+	}
+	kept := me.queue[:0]
+	for _, it := range me.queue {
+		if pred(it.msg) {
+			me.release(it.meta)
+			continue
+		}
+		kept = append(kept, it)
+	}
+	n := len(me.queue) - len(kept)
+	clear(me.queue[len(kept):])
+	me.queue = kept
+	return n
+}
+
+// length returns how many messages are buffered.
+func (me *Inner[T]) length() int {
+	if me.custom != nil {
+		return me.custom.Len()
+	}
+	return len(me.queue)
+}
+
+// peek returns the message pop would return next. Call has_next first.
+func (me *Inner[T]) peek() T {
+	if me.custom != nil {
+		return me.custom.Peek()
+	}
+	return me.queue[0].msg
+}
+
+// abandon ends the channel from the receiving side: whatever is buffered is
+// discarded and further sends are dropped.
+func (me *Inner[T]) abandon() {
+	if me.is_abandoned {
+		return
+	}
+	me.is_abandoned = true
+	me.clear()
+	me.stop_deadline()
+	close(me.abandoned)
+	me.notify()
+}
+
+// clear discards every queued message.
+func (me *Inner[T]) clear() {
+	for me.custom != nil && me.custom.Len() > 0 {
+		me.custom.Pop()
+		me.release(nil)
+	}
+	for _, it := range me.queue {
+		me.release(it.meta)
+	}
+	me.queue = nil
+}
+
+// has_next reports whether a message is ready to pop, first discarding any
+// expired messages at the head of the queue.
+func (me *Inner[T]) has_next() bool {
+	if me.custom != nil {
+		return me.custom.Len() > 0
+	}
+	var now time.Time
+	for len(me.queue) > 0 {
+		if me.less != nil {
+			me.promote()
+		}
+		m := me.queue[0].meta
+		if m == nil || m.expires.IsZero() {
+			return true
+		}
+		if now.IsZero() {
+			now = time.Now()
+		}
+		if now.Before(m.expires) {
+			return true
+		}
+		me.release(me.queue[0].meta)
+		me.queue = me.queue[1:]
+		me.n_expired += 1
+	}
+	return false
+}
+
+// promote moves the message an aging priority channel should deliver next
+// to the head of its queue. It scans the whole queue, so every receive costs
+// time proportional to the backlog.
+func (me *Inner[T]) promote() {
+	best := 0
+	for i := 1; i < len(me.queue); i++ {
+		if me.ahead(me.queue[i], me.queue[best]) {
+			best = i
+		}
+	}
+	if best > 0 {
+		it := me.queue[best]
+		copy(me.queue[1:best+1], me.queue[:best])
+		me.queue[0] = it
+	}
+}
+
+// ahead reports whether a should be received before b. Once one has waited
+// long enough longer than the other to be a whole step ahead, it goes first
+// whatever less says.
+func (me *Inner[T]) ahead(a item[T], b item[T]) bool {
+	steps := b.meta.sent.Sub(a.meta.sent).Seconds() * me.age_per_second
+	if steps >= 1 {
+		return true
+	}
+	if steps <= -1 {
+		return false
+	}
+	return me.less(a.msg, b.msg)
+}
+
+func (me *Inner[T]) pop() T {
+	return *me.pop_ptr()
+}
+
+// pop_item pops the head of the queue along with its metadata, so that
+// unpop can restore it exactly.
+func (me *Inner[T]) pop_item() item[T] {
+	var m *meta
+	if me.custom == nil {
+		m = me.queue[0].meta
+	}
+	return item[T]{msg: *me.pop_ptr(), meta: m}
+}
+
+// pop_ptr pops the head of the queue without copying the message out. The
+// slot it points to is never reused, since the queue only ever advances past
+// it or appends beyond its end.
+func (me *Inner[T]) pop_ptr() *T {
+	if me.custom != nil {
+		msg := me.custom.Pop()
+		me.release(nil)
+		me.last_recv = time.Now()
+		return &msg
+	}
+	msg := &me.queue[0].msg
+	m := me.queue[0].meta
+	me.release(m)
+	me.queue = me.queue[1:]
+	me.last_recv = time.Now()
+	if me.is_latency_tracked {
+		me.record_latency(me.last_recv.Sub(m.sent))
+	}
+	return msg
+}
+
+// latency_samples bounds how many latencies a latency-tracked channel keeps.
+const latency_samples = 1024
+
+func (me *Inner[T]) record_latency(d time.Duration) {
+	if len(me.latencies) < latency_samples {
+		me.latencies = append(me.latencies, d)
+		return
+	}
+	me.latencies[me.next_latency] = d
+	me.next_latency = (me.next_latency + 1) % latency_samples
 }
 
 type Shared[T any] struct {
 	inner     *Inner[T]
 	available *sync.Cond
+	grown     *sync.Cond
+}
+
+// wake_all wakes every goroutine blocked on the channel, for state changes
+// such as close that any of them may be waiting for.
+func (me *Shared[T]) wake_all() {
+	me.available.Broadcast()
+	me.grown.Broadcast()
+	me.inner.room.Broadcast()
+}
+
+// wait_available blocks a receiver until a message may have arrived or the
+// channel may have closed. The inner lock must be held.
+func (me *Shared[T]) wait_available() {
+	me.inner.n_waiting += 1
+	me.available.Wait()
+	me.inner.n_waiting -= 1
+}
+
+// cut_short closes the channel regardless of its senders, recording err as
+// the reason and optionally discarding whatever is still buffered.
+func (me *Shared[T]) cut_short(err error, discard bool) {
+	me.inner.Lock()
+	if me.inner.err == nil {
+		me.inner.err = err
+	}
+	me.inner.is_cut_short = true
+	if discard {
+		me.inner.clear()
+	}
+	me.inner.mark_done()
+	me.inner.Unlock()
+	me.wake_all()
+}
+
+// wake_after wakes every blocked goroutine once d has passed, so that waits
+// with a timeout can notice it expired. Stop the returned timer when done.
+func (me *Shared[T]) wake_after(d time.Duration) *time.Timer {
+	return time.AfterFunc(d, func() {
+		me.inner.Lock()
+		me.inner.Unlock()
+		me.wake_all()
+	})
 }
 
 type Sender[T any] struct {
-	shared    *Shared[T]
-	is_closed bool
+	shared     *Shared[T]
+	hub        *hub[T]
+	is_closed  bool
+	is_moved   bool
+	last_async *Future
+	tag        any // set by CloneTagged
+	is_tagged  bool
 }
 
 type Receiver[T any] struct {
-	shared *Shared[T]
+	shared    *Shared[T]
+	hub       *hub[T]
+	is_weak   bool
+	is_closed bool
+	is_paused bool
+	last_recv time.Time // set by RecvWithIdleFlag
+	on_block  func()
+	saw_close bool
+	pump      chan T        // set by Chan
+	activity  chan struct{} // set by NotifyOnActivity
+	ready_ch  chan struct{} // set by ReadyChan
+	stop      chan struct{} // closed by StopChan
 }
 
 func NewChannel[T any]() (*Sender[T], *Receiver[T]) {
-	inner := &Inner[T]{n_senders: 1}
-	shared := &Shared[T]{inner: inner, available: sync.NewCond(inner)}
+	inner := &Inner[T]{
+		n_senders:   1,
+		n_receivers: 1,
+		done:        make(chan struct{}),
+		abandoned:   make(chan struct{}),
+		last_recv:   time.Now(),
+	}
+	inner.room = sync.NewCond(inner)
+	shared := &Shared[T]{inner: inner, available: sync.NewCond(inner), grown: sync.NewCond(inner)}
 	tx := &Sender[T]{shared: shared, is_closed: false}
 	rx := &Receiver[T]{shared: shared}
 	return tx, rx
 }
 
+// NewChannelWithInitialCap is NewChannel with room preallocated for
+// initialCap buffered messages, to avoid regrowing the queue during a burst
+// of known size. The channel is still unbounded. initialCap must not be
+// negative.
+func NewChannelWithInitialCap[T any](initialCap int) (*Sender[T], *Receiver[T]) {
+	if initialCap < 0 {
+		panic("manchan: initial capacity must not be negative")
+	}
+	tx, rx := NewChannel[T]()
+	tx.shared.inner.queue = make([]item[T], 0, initialCap)
+	return tx, rx
+}
+
+// NewChannelWithQueue returns a channel that stores its buffered messages in
+// q, so that q decides the order they are received in, for instance LIFO or
+// by priority. q must start empty and must not be used by anything else.
+// A custom Queue stores bare messages, while TTLs, tiers, byte bounds, aging
+// priorities and latency tracking keep per-message metadata in the built-in
+// storage, so none of those can be combined with a custom Queue. For the same
+// reason SendWithDeadline and SendTrackedBounded panic on such a channel.
+func NewChannelWithQueue[T any](q Queue[T]) (*Sender[T], *Receiver[T]) {
+	tx, rx := NewChannel[T]()
+	tx.shared.inner.custom = q
+	return tx, rx
+}
+
+// stack is a LIFO Queue.
+type stack[T any] []T
+
+func (me *stack[T]) Push(msg T) { *me = append(*me, msg) }
+
+func (me *stack[T]) Pop() T {
+	n := len(*me) - 1
+	msg := (*me)[n]
+	var zero T
+	(*me)[n] = zero
+	*me = (*me)[:n]
+	return msg
+}
+
+func (me *stack[T]) Peek() T { return (*me)[len(*me)-1] }
+
+func (me *stack[T]) Len() int { return len(*me) }
+
+func (me *stack[T]) Remove(remove func(T) bool) int {
+	n := len(*me)
+	*me = slices.DeleteFunc(*me, remove)
+	return n - len(*me)
+}
+
+// NewStackChannel returns a channel that delivers the most recently sent
+// message first.
+func NewStackChannel[T any]() (*Sender[T], *Receiver[T]) {
+	return NewChannelWithQueue[T](&stack[T]{})
+}
+
+// NewChannelWithMaxClones returns a channel that allows at most maxSenders
+// open senders and maxReceivers open receivers at a time, counting the two it
+// returns, to catch clones created in a loop and never closed. A Clone that
+// would exceed a limit panics. Closing an endpoint frees its place. A limit
+// of zero or less means no limit.
+func NewChannelWithMaxClones[T any](maxSenders, maxReceivers int) (*Sender[T], *Receiver[T]) {
+	tx, rx := NewChannel[T]()
+	tx.shared.inner.max_senders = uint(max(maxSenders, 0))
+	tx.shared.inner.max_receivers = uint(max(maxReceivers, 0))
+	return tx, rx
+}
+
+// NewChannelWithDeadline returns a channel that closes itself at t, as if
+// every sender had closed. Messages still buffered at t remain drainable; use
+// NewChannelWithDeadlineDiscard to drop them instead. Sends after the
+// deadline are discarded.
+func NewChannelWithDeadline[T any](t time.Time) (*Sender[T], *Receiver[T]) {
+	return new_channel_with_deadline[T](t, false)
+}
+
+// NewChannelWithDeadlineDiscard is NewChannelWithDeadline, but any messages
+// still buffered at t are discarded.
+func NewChannelWithDeadlineDiscard[T any](t time.Time) (*Sender[T], *Receiver[T]) {
+	return new_channel_with_deadline[T](t, true)
+}
+
+func new_channel_with_deadline[T any](t time.Time, discard bool) (*Sender[T], *Receiver[T]) {
+	tx, rx := NewChannel[T]()
+	shared := tx.shared
+	shared.inner.Lock()
+	shared.inner.deadline = time.AfterFunc(time.Until(t), func() {
+		shared.cut_short(nil, discard)
+	})
+	shared.inner.Unlock()
+	return tx, rx
+}
+
+// NewChannelWithCancel returns a channel along with a cancel function that
+// closes it immediately from either side: buffered messages are discarded,
+// blocked receivers and senders return, further sends are dropped and Err
+// reports ErrCanceled. Calling cancel more than once has no further effect.
+func NewChannelWithCancel[T any]() (*Sender[T], *Receiver[T], func()) {
+	tx, rx := NewChannel[T]()
+	shared := tx.shared
+	return tx, rx, func() {
+		shared.cut_short(ErrCanceled, true)
+	}
+}
+
+// NewInstrumentedChannel returns a channel that counts how often taking its
+// lock had to wait for another goroutine, reported by Receiver.Stats. This
+// costs an extra TryLock per acquisition, so it is meant for tuning.
+func NewInstrumentedChannel[T any]() (*Sender[T], *Receiver[T]) {
+	tx, rx := NewChannel[T]()
+	tx.shared.inner.is_instrumented = true
+	return tx, rx
+}
+
+// NewLatencyTrackedChannel returns a channel that timestamps each message
+// when it is sent and measures how long it waited until received, for
+// Receiver.LatencyPercentiles.
+func NewLatencyTrackedChannel[T any]() (*Sender[T], *Receiver[T]) {
+	tx, rx := NewChannel[T]()
+	tx.shared.inner.is_latency_tracked = true
+	return tx, rx
+}
+
+// NewTTLChannel returns a channel whose messages expire ttl after being sent.
+// Expired messages are skipped by Recv and counted; see Receiver.Expired.
+func NewTTLChannel[T any](ttl time.Duration) (*Sender[T], *Receiver[T]) {
+	tx, rx := NewChannel[T]()
+	tx.shared.inner.ttl = ttl
+	return tx, rx
+}
+
+// NewBoundedChannel returns a channel that buffers at most capacity messages.
+// Send blocks while the buffer is full. If every receiver closes, blocked
+// sends return at once and their messages are dropped; SendAsync reports
+// this as ErrClosed. capacity must be positive: for an unbounded channel use
+// NewChannel.
+func NewBoundedChannel[T any](capacity int) (*Sender[T], *Receiver[T]) {
+	if capacity < 1 {
+		panic("manchan: capacity must be positive")
+	}
+	tx, rx := NewChannel[T]()
+	tx.shared.inner.max_size = capacity
+	return tx, rx
+}
+
+// NewDropOldestChannel returns a channel that buffers at most capacity
+// messages. A send to a full channel never blocks: it discards the oldest
+// buffered message to make room. onDrop, if not nil, is called with each
+// discarded message, outside the channel's lock.
+func NewDropOldestChannel[T any](capacity int, onDrop func(T)) (*Sender[T], *Receiver[T]) {
+	tx, rx := NewBoundedChannel[T](capacity)
+	tx.shared.inner.overflow = overflow_drop_oldest
+	tx.shared.inner.on_drop = onDrop
+	return tx, rx
+}
+
+// NewDropNewestChannel is NewDropOldestChannel, except that a send to a full
+// channel discards the message being sent and keeps the buffer as it is.
+func NewDropNewestChannel[T any](capacity int, onDrop func(T)) (*Sender[T], *Receiver[T]) {
+	tx, rx := NewBoundedChannel[T](capacity)
+	tx.shared.inner.overflow = overflow_drop_newest
+	tx.shared.inner.on_drop = onDrop
+	return tx, rx
+}
+
+// NewFairBoundedChannel is NewBoundedChannel, except that blocked senders are
+// let through strictly in the order they started sending, so that none can
+// be starved by later arrivals.
+func NewFairBoundedChannel[T any](capacity int) (*Sender[T], *Receiver[T]) {
+	tx, rx := NewBoundedChannel[T](capacity)
+	tx.shared.inner.is_fair = true
+	return tx, rx
+}
+
+// NewByteBoundedChannel returns a channel that buffers at most maxBytes worth
+// of messages, as measured by sizeof. Send blocks while adding the message
+// would exceed the budget, except that a message is always accepted into an
+// empty channel however large it is. sizeof is called once per message.
+// maxBytes must be positive.
+func NewByteBoundedChannel[T any](maxBytes int, sizeof func(T) int) (*Sender[T], *Receiver[T]) {
+	if maxBytes < 1 {
+		panic("manchan: maxBytes must be positive")
+	}
+	tx, rx := NewChannel[T]()
+	tx.shared.inner.max_size = maxBytes
+	tx.shared.inner.sizeof = sizeof
+	return tx, rx
+}
+
+// NewRecordingChannel returns a channel that also keeps a log of every
+// message sent, separate from its queue, for Receiver.History. Only the last
+// limit messages are kept, or all of them if limit is zero.
+func NewRecordingChannel[T any](limit int) (*Sender[T], *Receiver[T]) {
+	tx, rx := NewChannel[T]()
+	tx.shared.inner.is_recording = true
+	tx.shared.inner.history_limit = limit
+	return tx, rx
+}
+
+// NewAgingPriorityChannel returns a channel that delivers first whichever
+// buffered message less orders first, while letting messages age: for every
+// second a message has waited longer than another it moves agePerSecond
+// steps ahead of it, and a message a whole step ahead is delivered first
+// regardless of less. Low-priority messages are therefore delivered
+// eventually however many high-priority ones keep arriving. Each receive
+// scans the whole backlog.
+func NewAgingPriorityChannel[T any](less func(a, b T) bool, agePerSecond float64) (*Sender[T], *Receiver[T]) {
+	tx, rx := NewChannel[T]()
+	tx.shared.inner.less = less
+	tx.shared.inner.age_per_second = agePerSecond
+	return tx, rx
+}
+
+// NewTieredChannel returns a channel that delivers the message with the
+// highest prio first, in send order within a priority. capByPrio caps how many
+// messages of a given priority may be buffered; a send to a full tier is
+// dropped, so reserving room for high priorities keeps them flowing when low
+// priorities back up. Priorities missing from capByPrio are unbounded.
+func NewTieredChannel[T any](prio func(T) int, capByPrio map[int]int) (*Sender[T], *Receiver[T]) {
+	tx, rx := NewChannel[T]()
+	tx.shared.inner.prio = prio
+	tx.shared.inner.tier_cap = capByPrio
+	tx.shared.inner.tier_len = map[int]int{}
+	return tx, rx
+}
+
+// CloneTagged is Clone, except that the new sender carries tag, which is
+// listed by ActiveSenders once it has sent a message. Tags are not tracked
+// on broadcast channels. It is a function rather than a method so that the
+// tag can be of any comparable type.
+func CloneTagged[T any, K comparable](tx *Sender[T], tag K) *Sender[T] {
+	clone := tx.Clone()
+	clone.tag = tag
+	clone.is_tagged = true
+	return clone
+}
+
+// CloneEndpoints clones tx and rx in one call, for handing a worker its own
+// pair of endpoints on the same channel.
+func CloneEndpoints[T any](tx *Sender[T], rx *Receiver[T]) (*Sender[T], *Receiver[T]) {
+	return tx.Clone(), rx.Clone()
+}
+
+// Clone returns another sender on the same channel, which stays open until
+// every sender has closed. Cloning a closed sender panics: it may have been
+// the last, and a clone must not reopen a channel receivers saw close.
 func (me *Sender[T]) Clone() *Sender[T] {
 	me.shared.inner.Lock()
 	defer me.shared.inner.Unlock()
+	if me.is_moved {
+		panic(err_sender_moved.Error())
+	}
+	if me.is_closed {
+		panic(err_sender_closed.Error())
+	}
+	if limit := me.shared.inner.max_senders; limit > 0 && me.shared.inner.n_senders >= limit {
+		panic(err_too_many_senders.Error())
+	}
 	me.shared.inner.n_senders += 1
-	return &Sender[T]{shared: me.shared}
+	return &Sender[T]{shared: me.shared, hub: me.hub}
 }
 
 func (me *Sender[T]) Close() {
-	channel_closed := false
 	me.shared.inner.Lock()
-	me.is_closed = true
-	me.shared.inner.n_senders -= 1
-	if me.shared.inner.n_senders == 0 {
-		channel_closed = true
-	}
+	me.panic_if_moved()
+	channel_closed := me.close_locked()
 	me.shared.inner.Unlock()
-	if channel_closed {
-		me.shared.available.Broadcast()
-	}
+	me.after_close(channel_closed)
 }
 
-func (me *Sender[T]) Send(msg T) {
+// Transfer hands this sender's place in the channel over to a new sender,
+// which it returns, for handing production off to another goroutine. The
+// original is left moved: using it to send, clone or close panics, so that it
+// cannot keep producing or close the channel behind the new owner's back.
+func (me *Sender[T]) Transfer() *Sender[T] {
+	me.shared.inner.Lock()
+	me.panic_if_moved()
 	if me.is_closed {
-		panic("Attempt to send on closed sender")
+		me.shared.inner.Unlock()
+		panic(err_sender_closed.Error())
 	}
-	me.shared.inner.Lock()
-	me.shared.inner.queue = append(me.shared.inner.queue, msg)
+	me.is_moved = true
+	me.is_closed = true
+	moved := &Sender[T]{shared: me.shared, hub: me.hub, last_async: me.last_async, tag: me.tag, is_tagged: me.is_tagged}
 	me.shared.inner.Unlock()
-	me.shared.available.Signal()
+	return moved
 }
 
-func (me *Receiver[T]) Clone() *Receiver[T] {
-	return &Receiver[T]{shared: me.shared}
+// WaitForEmpty blocks until every buffered message has been received or
+// discarded.
+func (me *Sender[T]) WaitForEmpty() {
+	me.wait_for_empty(time.Time{})
 }
 
-func (me *Receiver[T]) Recv() (T, bool) {
+// WaitForEmptyTimeout is WaitForEmpty giving up after d, so that shutdown
+// cannot hang on a stalled consumer. It reports whether the buffer emptied.
+func (me *Sender[T]) WaitForEmptyTimeout(d time.Duration) bool {
+	return me.wait_for_empty(time.Now().Add(d))
+}
+
+// wait_for_empty waits for the queue to empty, or until deadline if it is
+// set.
+func (me *Sender[T]) wait_for_empty(deadline time.Time) bool {
+	var timer *time.Timer
+	me.shared.inner.Lock()
+	defer func() {
+		me.shared.inner.Unlock()
+		if timer != nil {
+			timer.Stop()
+		}
+	}()
+	for me.shared.inner.length() > 0 {
+		if !deadline.IsZero() && !time.Now().Before(deadline) {
+			return false
+		}
+		if !deadline.IsZero() && timer == nil {
+			timer = me.shared.wake_after(time.Until(deadline))
+		}
+		me.shared.inner.n_drain_waiters += 1
+		me.shared.inner.room.Wait()
+		me.shared.inner.n_drain_waiters -= 1
+	}
+	return true
+}
+
+// CancelWhere retracts every buffered message matching pred that no
+// receiver has taken yet, keeping the others in order, and returns how many
+// it retracted. It is the sending side's counterpart to Receiver.Compact.
+func (me *Sender[T]) CancelWhere(pred func(T) bool) int {
+	me.shared.inner.Lock()
+	defer me.shared.inner.Unlock()
+	return me.shared.inner.remove_where(pred)
+}
+
+// Unbound lifts a bounded channel's capacity, releasing every blocked
+// sender, for riding out a burst. Rebound puts a cap back.
+func (me *Sender[T]) Unbound() {
+	me.Rebound(0)
+}
+
+// Rebound caps the channel at n, as NewBoundedChannel would, or lifts the cap
+// if n is zero. A backlog already over the new cap is kept, and sends block
+// until it drops below. n must not be negative.
+func (me *Sender[T]) Rebound(n int) {
+	if n < 0 {
+		panic("manchan: capacity must not be negative")
+	}
+	me.shared.inner.Lock()
+	me.shared.inner.max_size = n
+	me.shared.inner.notify()
+	me.shared.inner.Unlock()
+	me.shared.inner.room.Broadcast()
+}
+
+// panic_if_moved panics if this sender was moved by Transfer, releasing the
+// lock first. Call it with the lock held.
+func (me *Sender[T]) panic_if_moved() {
+	if me.is_moved {
+		me.shared.inner.Unlock()
+		panic(err_sender_moved.Error())
+	}
+}
+
+// close_locked closes this sender with the lock held, reporting whether it
+// was the last one. Pass the result to after_close once unlocked.
+func (me *Sender[T]) close_locked() bool {
+	if me.is_closed {
+		return false
+	}
+	me.is_closed = true
+	me.shared.inner.n_senders -= 1
+	if me.shared.inner.n_senders == 0 {
+		me.shared.inner.mark_done()
+		return true
+	}
+	return false
+}
+
+func (me *Sender[T]) after_close(channel_closed bool) {
+	if channel_closed {
+		me.shared.wake_all()
+		me.shared.check_woken()
+		if me.hub != nil {
+			me.shared.inner.Lock()
+			err := me.shared.inner.err
+			me.shared.inner.Unlock()
+			me.hub.close(err)
+		}
+	}
+}
+
+// CloseAndWaitReceivers closes this sender and then blocks until every
+// receiver has seen the channel closed, by a receive reporting it closed, or
+// has closed itself. Weak receivers are not waited for, nor are the
+// receivers of a broadcast channel. If other senders remain open it waits
+// for them to close too.
+func (me *Sender[T]) CloseAndWaitReceivers() {
+	me.Close()
+	if me.hub != nil {
+		return
+	}
+	me.shared.inner.Lock()
+	for me.shared.inner.n_close_acks < me.shared.inner.n_receivers {
+		me.shared.inner.n_close_waiters += 1
+		me.shared.inner.room.Wait()
+		me.shared.inner.n_close_waiters -= 1
+	}
+	me.shared.inner.Unlock()
+}
+
+// CloseAndDrain closes this sender and, if it was the last one, takes and
+// returns every message still buffered, so an abort path can persist work
+// that no receiver will get to. It returns nil if other senders remain.
+func (me *Sender[T]) CloseAndDrain() []T {
+	var msgs []T
+	me.shared.inner.Lock()
+	me.panic_if_moved()
+	channel_closed := me.close_locked()
+	if channel_closed {
+		for me.shared.inner.has_next() {
+			msgs = append(msgs, me.shared.inner.pop())
+		}
+	}
+	me.shared.inner.Unlock()
+	me.after_close(channel_closed)
+	return msgs
+}
+
+// Done returns a channel that is closed once every sender has closed.
+func (me *Sender[T]) Done() <-chan struct{} {
+	return me.shared.inner.done
+}
+
+// abandoned returns a channel that is closed once every receiver has closed.
+func (me *Sender[T]) abandoned() <-chan struct{} {
+	return me.shared.inner.abandoned
+}
+
+// Fail ends the stream with err: it records err as the reason the channel
+// closed, unless an earlier error was already recorded, and closes this
+// sender. Receivers still get every message sent before Fail; once the
+// channel reports closed, Err returns err. If other senders remain open the
+// channel stays open until they close too.
+func (me *Sender[T]) Fail(err error) {
+	me.shared.inner.Lock()
+	if me.shared.inner.err == nil {
+		me.shared.inner.err = err
+	}
+	me.shared.inner.Unlock()
+	me.Close()
+}
+
+func (me *Sender[T]) Send(msg T) {
+	if err := me.send(msg, true); err == err_sender_closed {
+		panic(err.Error())
+	}
+}
+
+// send enqueues msg. If a bounded channel is full it blocks, or returns
+// ErrFull if block is false. A message the channel discards because it has
+// ended early reports ErrClosed.
+func (me *Sender[T]) send(msg T, block bool) error {
+	return me.send_with(msg, block, send_opts{})
+}
+
+// send_opts carries what a send may attach to its message.
+type send_opts struct {
+	// If consumed is not nil the send first waits until fewer than
+	// max_in_flight tracked messages are unconsumed, and consumed is closed
+	// once the message leaves the queue or is discarded.
+	consumed      chan struct{}
+	max_in_flight int
+	// expires, if set, is when the message expires, unless the channel's TTL
+	// runs out sooner.
+	expires time.Time
+	// backlog, if not nil, is set to how many messages are buffered right
+	// after the send.
+	backlog *int
+}
+
+// send_with is send, attaching whatever opts asks for to the message.
+func (me *Sender[T]) send_with(msg T, block bool, opts send_opts) error {
+	// is_closed is checked under the lock so that a receiver which has seen
+	// n_senders hit zero can never see another message appended after it.
+	me.shared.inner.Lock()
+	me.panic_if_moved()
+	if me.is_closed {
+		me.shared.inner.Unlock()
+		return err_sender_closed
+	}
+	if me.hub != nil {
+		me.shared.inner.Unlock()
+		me.hub.send(msg)
+		if opts.consumed != nil {
+			close(opts.consumed)
+		}
+		return nil
+	}
+	if me.shared.inner.custom != nil && (opts.consumed != nil || !opts.expires.IsZero()) {
+		me.shared.inner.Unlock()
+		panic("manchan: tracked and deadline sends need the built-in queue")
+	}
+	it := me.shared.inner.wrap(msg)
+	if (opts.consumed != nil || !opts.expires.IsZero()) && it.meta == nil {
+		it.meta = &meta{}
+	}
+	if !opts.expires.IsZero() && (it.meta.expires.IsZero() || opts.expires.Before(it.meta.expires)) {
+		it.meta.expires = opts.expires
+	}
+	if opts.consumed != nil {
+		it.meta.consumed = opts.consumed
+		me.shared.inner.wait_for_credit(opts.max_in_flight)
+	}
+	var dropped []T
+	if me.shared.inner.overflow != overflow_block && !me.shared.inner.drops_sends() {
+		dropped = me.shared.inner.shed(it)
+		if me.shared.inner.overflow == overflow_drop_newest && len(dropped) > 0 {
+			me.shared.inner.untrack(it.meta)
+			me.shared.inner.Unlock()
+			me.drop(dropped)
+			return nil
+		}
+	}
+	if !block && !me.shared.inner.drops_sends() && !me.shared.inner.has_room(it) {
+		me.shared.inner.untrack(it.meta)
+		me.shared.inner.Unlock()
+		return ErrFull
+	}
+	me.shared.inner.wait_for_room(it)
+	if me.shared.inner.drops_sends() {
+		me.shared.inner.untrack(it.meta)
+		me.shared.inner.Unlock()
+		return ErrClosed
+	}
+	// push refuses a message whose tier is full.
+	pushed := me.shared.inner.push(it)
+	if !pushed {
+		me.shared.inner.untrack(it.meta)
+	}
+	if pushed && me.is_tagged {
+		if me.shared.inner.active_tags == nil {
+			me.shared.inner.active_tags = map[any]struct{}{}
+		}
+		if _, seen := me.shared.inner.active_tags[me.tag]; !seen {
+			me.shared.inner.active_tags[me.tag] = struct{}{}
+			me.shared.inner.tag_order = append(me.shared.inner.tag_order, me.tag)
+		}
+	}
+	if opts.backlog != nil {
+		*opts.backlog = me.shared.inner.length()
+	}
+	backlog_waiters := me.shared.inner.n_backlog_waiters > 0
+	any_paused := me.shared.inner.n_paused > 0 || me.shared.inner.n_gated > 0
+	me.shared.inner.Unlock()
+	me.drop(dropped)
+	if pushed {
+		if any_paused {
+			me.shared.available.Broadcast()
+		} else {
+			me.shared.available.Signal()
+		}
+		if backlog_waiters {
+			me.shared.grown.Broadcast()
+		}
+	}
+	if !pushed {
+		return ErrFull
+	}
+	return nil
+}
+
+// SendReturningBacklog sends msg and returns how many messages are buffered
+// right after it, read under the same lock, so that a producer can slow down
+// as the backlog grows without a separate, racing Len call. On a broadcast
+// channel it returns 0.
+func (me *Sender[T]) SendReturningBacklog(msg T) int {
+	backlog := 0
+	if err := me.send_with(msg, true, send_opts{backlog: &backlog}); err == err_sender_closed {
+		panic(err.Error())
+	}
+	return backlog
+}
+
+// SendWithDeadline sends msg to expire at t: once t has passed it is skipped
+// by receives and counted in Receiver.Expired, as on a TTL channel. A message
+// is only checked when it reaches the head of the queue. It panics on a
+// channel with a custom Queue, such as a stack or spilling channel.
+func (me *Sender[T]) SendWithDeadline(msg T, t time.Time) {
+	if err := me.send_with(msg, true, send_opts{expires: t}); err == err_sender_closed {
+		panic(err.Error())
+	}
+}
+
+// SendTrackedBounded sends msg and returns a channel that is closed once msg
+// has been received, or discarded without being received. If maxInFlight
+// tracked messages are already unconsumed it first blocks until one is, so
+// that producers are held back by consumption rather than by buffer size.
+// The count of unconsumed tracked messages belongs to the channel, not to
+// this sender: every sender's tracked messages count against the limit, so
+// senders sharing a channel should pass the same maxInFlight. maxInFlight
+// must be positive. Tracking is not supported on broadcast senders, whose
+// channel is closed as soon as msg is sent, and panics on a channel with a
+// custom Queue, such as a stack or spilling channel.
+func (me *Sender[T]) SendTrackedBounded(msg T, maxInFlight int) <-chan struct{} {
+	if maxInFlight < 1 {
+		panic("manchan: maxInFlight must be positive")
+	}
+	consumed := make(chan struct{})
+	opts := send_opts{consumed: consumed, max_in_flight: maxInFlight}
+	if err := me.send_with(msg, true, opts); err == err_sender_closed {
+		panic(err.Error())
+	}
+	return consumed
+}
+
+// drop reports messages a drop channel discarded. It is called without the
+// lock so that on_drop may use the channel.
+func (me *Sender[T]) drop(dropped []T) {
+	if me.shared.inner.on_drop == nil {
+		return
+	}
+	for _, msg := range dropped {
+		me.shared.inner.on_drop(msg)
+	}
+}
+
+// Send2 sends msg, waiting for room in a full bounded channel if block is
+// true or returning ErrFull at once if it is false. It also returns ErrFull
+// if a tiered channel dropped msg because its tier was full. It returns
+// ErrClosed if this sender is closed or the channel has ended early and
+// dropped msg.
+func (me *Sender[T]) Send2(msg T, block bool) error {
+	err := me.send(msg, block)
+	if err == err_sender_closed {
+		return ErrClosed
+	}
+	return err
+}
+
+// SendOrClose sends msg if there is room for it right away. Otherwise it
+// closes this sender instead and returns false, shedding load by
+// disconnecting rather than waiting.
+func (me *Sender[T]) SendOrClose(msg T) bool {
+	err := me.send(msg, false)
+	if err == err_sender_closed {
+		panic(err.Error())
+	}
+	if err != nil {
+		me.Close()
+		return false
+	}
+	return true
+}
+
+// Future is the pending result of SendAsync.
+type Future struct {
+	done chan struct{}
+	err  error
+}
+
+// Wait blocks until the message has been enqueued, returning nil, or could
+// not be because the sender or channel closed, returning ErrClosed, or
+// because its tier was full, returning ErrFull.
+func (me *Future) Wait() error {
+	<-me.done
+	return me.err
+}
+
+// SendAsync sends msg from a new goroutine, so that a full bounded channel
+// does not block the caller. Async sends from the same sender are enqueued in
+// the order they were made. A pending async send holds the channel open as a
+// sender of its own, so closing this sender right after still delivers msg.
+func (me *Sender[T]) SendAsync(msg T) *Future {
+	future := &Future{done: make(chan struct{})}
+	me.shared.inner.Lock()
+	me.panic_if_moved()
+	if me.is_closed {
+		me.shared.inner.Unlock()
+		future.err = ErrClosed
+		close(future.done)
+		return future
+	}
+	me.shared.inner.n_senders += 1
+	pending := &Sender[T]{shared: me.shared, hub: me.hub, tag: me.tag, is_tagged: me.is_tagged}
+	prev := me.last_async
+	me.last_async = future
+	me.shared.inner.Unlock()
+	go func() {
+		if prev != nil {
+			<-prev.done
+		}
+		switch err := pending.send(msg, true); err {
+		case nil:
+		case ErrFull:
+			future.err = ErrFull
+		default:
+			future.err = ErrClosed
+		}
+		pending.Close()
+		close(future.done)
+	}()
+	return future
+}
+
+// Err returns the error the channel was closed with, if any. It is meant to
+// be checked after Recv reports the channel closed, and returns nil while
+// messages sent before the error are still buffered.
+func (me *Receiver[T]) Err() error {
+	me.shared.inner.Lock()
+	defer me.shared.inner.Unlock()
+	if !me.shared.inner.closed() || me.shared.inner.has_next() {
+		return nil
+	}
+	return me.shared.inner.err
+}
+
+// Close releases this receiver. Once every receiver except weak ones has
+// closed, the channel is abandoned: buffered messages are discarded, further
+// sends are dropped and Recv reports the channel closed. Closing a receiver
+// twice has no further effect.
+func (me *Receiver[T]) Close() {
+	me.shared.inner.Lock()
+	channel_abandoned := me.close_locked()
+	me.shared.inner.Unlock()
+	if channel_abandoned {
+		me.shared.wake_all()
+	}
+}
+
+// close_locked closes this receiver with the lock held, reporting whether it
+// was the last one, which abandons the channel. If so, call wake_all once
+// unlocked.
+func (me *Receiver[T]) close_locked() bool {
+	if me.is_closed {
+		return false
+	}
+	me.is_closed = true
+	me.unsubscribe()
+	if me.is_paused {
+		me.is_paused = false
+		me.shared.inner.n_paused -= 1
+	}
+	if !me.is_weak {
+		me.shared.inner.n_receivers -= 1
+		if me.saw_close {
+			me.shared.inner.n_close_acks -= 1
+		}
+		if me.shared.inner.n_close_waiters > 0 {
+			me.shared.inner.room.Broadcast()
+		}
+		if me.shared.inner.n_receivers == 0 {
+			me.shared.inner.abandon()
+			return true
+		}
+	} else if me.hub != nil {
+		// A weak broadcast receiver is alone on its subscription channel;
+		// abandoning it lets the hub drop the subscription on its next send.
+		me.shared.inner.abandon()
+		return true
+	}
+	return false
+}
+
+// unsubscribe stops notifying the channels returned by NotifyOnActivity and
+// ReadyChan.
+func (me *Receiver[T]) unsubscribe() {
+	if me.activity != nil {
+		me.shared.inner.activity = slices.DeleteFunc(me.shared.inner.activity, func(ch chan struct{}) bool {
+			return ch == me.activity
+		})
+	}
+	if me.ready_ch != nil {
+		me.shared.inner.unwatch(me.ready_ch)
+	}
+}
+
+// DrainClose takes every message still buffered, closes this receiver and
+// abandons the channel, even if other receivers remain, so that producers
+// stop: blocked and later sends are dropped. It is a one-call teardown for a
+// consumer that owns the channel. The error is the one the channel was
+// failed with, if any, or ErrClosed if this receiver was already closed.
+func (me *Receiver[T]) DrainClose() ([]T, error) {
+	me.shared.inner.Lock()
+	if me.is_closed {
+		me.shared.inner.Unlock()
+		return nil, ErrClosed
+	}
+	var msgs []T
+	for me.shared.inner.has_next() {
+		msgs = append(msgs, me.shared.inner.pop())
+	}
+	if !me.close_locked() {
+		me.shared.inner.abandon()
+	}
+	err := me.shared.inner.err
+	me.shared.inner.Unlock()
+	me.shared.wake_all()
+	return msgs, err
+}
+
+// Expired returns the number of messages discarded because they expired
+// before being received.
+func (me *Receiver[T]) Expired() uint64 {
+	me.shared.inner.Lock()
+	defer me.shared.inner.Unlock()
+	return me.shared.inner.n_expired
+}
+
+func (me *Receiver[T]) Clone() *Receiver[T] {
+	if me.hub != nil {
+		return me.hub.subscribe(false)
+	}
+	me.shared.inner.Lock()
+	defer me.shared.inner.Unlock()
+	if limit := me.shared.inner.max_receivers; limit > 0 && me.shared.inner.n_receivers >= limit {
+		panic(err_too_many_receivers.Error())
+	}
+	me.shared.inner.n_receivers += 1
+	return &Receiver[T]{shared: me.shared}
+}
+
+// CloneWeak returns a receiver that competes for messages like any other
+// clone but does not count as a live receiver: it neither keeps the channel
+// from being abandoned nor needs to be closed. A weak receiver observes close
+// exactly when the others do. In broadcast mode a weak clone still gets its
+// own copy of every message, and must be closed once no longer needed: until
+// then every broadcast keeps queueing messages for it.
+func (me *Receiver[T]) CloneWeak() *Receiver[T] {
+	if me.hub != nil {
+		return me.hub.subscribe(true)
+	}
+	return &Receiver[T]{shared: me.shared, is_weak: true}
+}
+
+// ready reports whether this receiver may pop a message right now.
+func (me *Receiver[T]) ready() bool {
+	return !me.is_paused && me.shared.inner.has_next()
+}
+
+// ended reports whether this receiver will never get another message.
+func (me *Receiver[T]) ended() bool {
+	if !me.shared.inner.closed() || me.shared.inner.has_next() {
+		return false
+	}
+	me.shared.inner.is_end_seen = true
+	if !me.saw_close && !me.is_weak {
+		me.saw_close = true
+		me.shared.inner.n_close_acks += 1
+		if me.shared.inner.n_close_waiters > 0 {
+			me.shared.inner.room.Broadcast()
+		}
+	}
+	return true
+}
+
+// Pause stops this receiver from taking messages: its receives block, even
+// with messages buffered, until Resume. Other receivers are unaffected.
+func (me *Receiver[T]) Pause() {
+	me.shared.inner.Lock()
+	defer me.shared.inner.Unlock()
+	if !me.is_paused {
+		me.is_paused = true
+		me.shared.inner.n_paused += 1
+	}
+}
+
+// Resume lets a paused receiver take messages again.
+func (me *Receiver[T]) Resume() {
+	me.shared.inner.Lock()
+	if me.is_paused {
+		me.is_paused = false
+		me.shared.inner.n_paused -= 1
+		me.shared.inner.notify()
+	}
+	me.shared.inner.Unlock()
+	me.shared.available.Broadcast()
+}
+
+// OnBlock sets cb to be called, from the receiving goroutine and without the
+// channel's lock held, whenever a receive finds nothing to receive and is
+// about to block, so that a scheduler can feed the idle receiver. A nil cb
+// removes the callback.
+func (me *Receiver[T]) OnBlock(cb func()) {
+	me.shared.inner.Lock()
+	me.on_block = cb
+	me.shared.inner.Unlock()
+}
+
+// wait blocks until the channel is signalled. The first time in a receive it
+// instead calls the OnBlock callback, if any, without the lock and returns
+// at once, so that the caller checks the channel again before blocking.
+func (me *Receiver[T]) wait(announced *bool) {
+	if me.on_block != nil && !*announced {
+		*announced = true
+		on_block := me.on_block
+		me.shared.inner.Unlock()
+		on_block()
+		me.shared.inner.Lock()
+		return
+	}
+	me.shared.wait_available()
+}
+
+func (me *Receiver[T]) Recv() (T, bool) {
+	announced := false
 	me.shared.inner.Lock()
 	for {
-		if len(me.shared.inner.queue) > 0 {
-			msg := me.shared.inner.queue[0]
-			me.shared.inner.queue = me.shared.inner.queue[1:]
+		if me.ready() {
+			msg := me.shared.inner.pop()
 			me.shared.inner.Unlock()
 			return msg, true
 		}
-		if me.shared.inner.n_senders == 0 {
+		if me.ended() {
 			me.shared.inner.Unlock()
 			return *new(T), false
 		}
-		me.shared.available.Wait()
+		me.wait(&announced)
+	}
+}
+
+// RecvTimed is Recv, additionally reporting how long it blocked waiting for a
+// message (zero if one was already buffered).
+func (me *Receiver[T]) RecvTimed() (msg T, ok bool, waited time.Duration) {
+	announced := false
+	var blocked_at time.Time
+	me.shared.inner.Lock()
+	for {
+		if me.ready() || me.ended() {
+			break
+		}
+		if blocked_at.IsZero() {
+			blocked_at = time.Now()
+		}
+		me.wait(&announced)
+	}
+	if !blocked_at.IsZero() {
+		waited = time.Since(blocked_at)
+	}
+	if me.shared.inner.length() == 0 {
+		me.shared.inner.Unlock()
+		return *new(T), false, waited
+	}
+	msg = me.shared.inner.pop()
+	me.shared.inner.Unlock()
+	return msg, true, waited
+}
+
+// RecvCancel is Recv that gives up once cancel is closed, for callers that
+// already have a done channel rather than a context. A cancelled receive
+// returns ok false and cancelled true; a closed channel returns both false.
+// A watcher goroutine is only started if RecvCancel actually blocks.
+func (me *Receiver[T]) RecvCancel(cancel <-chan struct{}) (msg T, ok bool, cancelled bool) {
+	it, ok, cancelled := me.recv_cancel(cancel)
+	return it.msg, ok, cancelled
+}
+
+// recv_cancel is RecvCancel returning the message along with its metadata,
+// so that it can be put back with unpop.
+func (me *Receiver[T]) recv_cancel(cancel <-chan struct{}) (it item[T], ok bool, cancelled bool) {
+	var done, exited chan struct{}
+	announced := false
+	// fired is set under the lock if the watcher took a value sent on cancel.
+	fired := false
+	me.shared.inner.Lock()
+	for {
+		// Cancellation comes first: a value the watcher took from cancel
+		// must not be swallowed by returning a message instead.
+		if !fired {
+			select {
+			case <-cancel:
+				fired = true
+			default:
+			}
+		}
+		if fired {
+			me.shared.inner.Unlock()
+			if done != nil {
+				close(done)
+			}
+			return it, false, true
+		}
+		if done != nil && (me.ready() || me.ended()) {
+			// Stop the watcher before returning, then look again, in case
+			// it has taken a value from cancel but not yet set fired.
+			me.shared.inner.Unlock()
+			close(done)
+			<-exited
+			done = nil
+			me.shared.inner.Lock()
+			continue
+		}
+		if me.ready() {
+			it = me.shared.inner.pop_item()
+			me.shared.inner.Unlock()
+			return it, true, false
+		}
+		if me.ended() {
+			me.shared.inner.Unlock()
+			return it, false, false
+		}
+		if done == nil {
+			done = make(chan struct{})
+			exited = make(chan struct{})
+			go func(done chan struct{}, exited chan struct{}) {
+				defer close(exited)
+				select {
+				case <-cancel:
+					me.shared.inner.Lock()
+					fired = true
+					me.shared.inner.Unlock()
+					me.shared.available.Broadcast()
+				case <-done:
+				}
+			}(done, exited)
+		}
+		me.wait(&announced)
+	}
+}
+
+// InterruptStatus tells whether RecvInterruptible was interrupted.
+type InterruptStatus int
+
+const (
+	NotInterrupted InterruptStatus = iota
+	Interrupted
+)
+
+// RecvInterruptible is Recv that returns Interrupted, without taking a
+// message, if interrupt is closed or sent a value while it waits, so that the
+// caller can do priority work and then receive again. The channel is
+// unaffected. ok reports whether a message was received, and is false with
+// NotInterrupted once the channel has closed.
+func (me *Receiver[T]) RecvInterruptible(interrupt <-chan struct{}) (T, InterruptStatus, bool) {
+	msg, ok, cancelled := me.RecvCancel(interrupt)
+	if cancelled {
+		return msg, Interrupted, false
+	}
+	return msg, NotInterrupted, ok
+}
+
+// RecvContext is Recv that gives up when ctx is done, returning ctx.Err().
+// A closed channel is reported as ok == false with a nil error.
+func (me *Receiver[T]) RecvContext(ctx context.Context) (T, bool, error) {
+	msg, ok, cancelled := me.RecvCancel(ctx.Done())
+	if cancelled {
+		return msg, false, ctx.Err()
+	}
+	return msg, ok, nil
+}
+
+// Consume calls f on every received message until the channel closes, f
+// returns an error or ctx is done, and returns that error or ctx.Err(). Once
+// the channel closes it returns Err, which is nil unless the stream failed.
+// It is meant as the body of an errgroup worker.
+func (me *Receiver[T]) Consume(ctx context.Context, f func(T) error) error {
+	for {
+		msg, ok, err := me.RecvContext(ctx)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return me.Err()
+		}
+		if err := f(msg); err != nil {
+			return err
+		}
+	}
+}
+
+// AllContext iterates over received messages until the channel closes or ctx
+// is done.
+func (me *Receiver[T]) AllContext(ctx context.Context) iter.Seq[T] {
+	return func(yield func(T) bool) {
+		for {
+			msg, ok, err := me.RecvContext(ctx)
+			if !ok || err != nil || !yield(msg) {
+				return
+			}
+		}
+	}
+}
+
+// RecvBurst blocks for the first message, then also takes every other message
+// already buffered, without waiting for more.
+func (me *Receiver[T]) RecvBurst() ([]T, bool) {
+	announced := false
+	me.shared.inner.Lock()
+	for {
+		if me.ready() {
+			msgs := []T{}
+			for me.shared.inner.has_next() {
+				msgs = append(msgs, me.shared.inner.pop())
+			}
+			me.shared.inner.Unlock()
+			return msgs, true
+		}
+		if me.ended() {
+			me.shared.inner.Unlock()
+			return nil, false
+		}
+		me.wait(&announced)
+	}
+}
+
+// WaitForBacklog blocks until at least n messages are buffered or the channel
+// closes, without receiving any of them.
+func (me *Receiver[T]) WaitForBacklog(n int) {
+	me.shared.inner.Lock()
+	me.shared.inner.n_backlog_waiters += 1
+	for me.shared.inner.length() < n && !me.shared.inner.closed() {
+		me.shared.grown.Wait()
+	}
+	me.shared.inner.n_backlog_waiters -= 1
+	me.shared.inner.Unlock()
+}
+
+// Status is the outcome of RecvOrIdle.
+type Status int
+
+const (
+	Delivered Status = iota
+	Idle
+	Closed
+)
+
+// RecvOrIdle is Recv that returns Idle instead of blocking for longer than d,
+// telling a live but quiet channel (Idle) apart from a finished one (Closed).
+func (me *Receiver[T]) RecvOrIdle(d time.Duration) (T, Status) {
+	announced := false
+	deadline := time.Now().Add(d)
+	var timer *time.Timer
+	me.shared.inner.Lock()
+	defer func() {
+		if timer != nil {
+			timer.Stop()
+		}
+	}()
+	for {
+		if me.ready() {
+			msg := me.shared.inner.pop()
+			me.shared.inner.Unlock()
+			return msg, Delivered
+		}
+		if me.ended() {
+			me.shared.inner.Unlock()
+			return *new(T), Closed
+		}
+		if !time.Now().Before(deadline) {
+			me.shared.inner.Unlock()
+			return *new(T), Idle
+		}
+		if timer == nil {
+			timer = me.shared.wake_after(time.Until(deadline))
+		}
+		me.wait(&announced)
+	}
+}
+
+// Compact discards every buffered message for which remove returns true,
+// keeping the others in order, and returns how many it discarded.
+func (me *Receiver[T]) Compact(remove func(T) bool) int {
+	me.shared.inner.Lock()
+	defer me.shared.inner.Unlock()
+	return me.shared.inner.remove_where(remove)
+}
+
+// ProducersBlocked returns how many sends are currently blocked waiting for
+// room in a bounded channel.
+func (me *Receiver[T]) ProducersBlocked() int {
+	me.shared.inner.Lock()
+	defer me.shared.inner.Unlock()
+	return me.shared.inner.n_blocked_senders
+}
+
+// Transfer moves up to limit already-buffered messages from src to dst, in
+// order, and returns how many it moved. It never blocks waiting for messages
+// and moves them in one batch, so dst's capacity is not enforced. Messages
+// dst refuses, because it has ended early or a tier is full, are put back at
+// the front of src, unless a receiver of src has meanwhile seen it closed.
+func Transfer[T any](src *Receiver[T], dst *Sender[T], limit int) int {
+	dst.shared.inner.Lock()
+	is_closed := dst.is_closed
+	drops := dst.shared.inner.drops_sends()
+	dst.shared.inner.Unlock()
+	if is_closed {
+		panic(err_sender_closed.Error())
+	}
+	if drops {
+		return 0
+	}
+
+	src.shared.inner.Lock()
+	its := []item[T]{}
+	for len(its) < limit && src.shared.inner.has_next() {
+		its = append(its, src.shared.inner.pop_item())
+	}
+	src.shared.inner.Unlock()
+	if len(its) == 0 {
+		return 0
+	}
+
+	if dst.hub != nil {
+		for _, it := range its {
+			dst.hub.send(it.msg)
+		}
+		return len(its)
+	}
+	var refused []item[T]
+	dst.shared.inner.Lock()
+	is_closed = dst.is_closed
+	if is_closed || dst.shared.inner.drops_sends() {
+		refused = its
+	} else {
+		for _, it := range its {
+			if !dst.shared.inner.push(dst.shared.inner.wrap(it.msg)) {
+				refused = append(refused, it)
+			}
+		}
+	}
+	backlog_waiters := dst.shared.inner.n_backlog_waiters > 0
+	dst.shared.inner.Unlock()
+	dst.shared.available.Broadcast()
+	if backlog_waiters {
+		dst.shared.grown.Broadcast()
+	}
+
+	if len(refused) > 0 {
+		src.shared.inner.Lock()
+		for i := len(refused) - 1; i >= 0; i-- {
+			src.shared.inner.unpop(refused[i])
+		}
+		src.shared.inner.Unlock()
+		src.shared.available.Broadcast()
+	}
+	if is_closed {
+		panic(err_sender_closed.Error())
+	}
+	return len(its) - len(refused)
+}
+
+// RecvWithFallback receives from this receiver until it closes, then from
+// fallback. It reports closed only once both have closed.
+func (me *Receiver[T]) RecvWithFallback(fallback *Receiver[T]) (T, bool) {
+	if msg, ok := me.Recv(); ok {
+		return msg, true
+	}
+	return fallback.Recv()
+}
+
+// NotifyOnActivity returns a channel that receives a value whenever a message
+// is sent, so that a scheduler can wait on this channel alongside other
+// events. Notifications are coalesced: the returned channel holds at most one
+// pending value, so several sends before it is drained show up as one, and a
+// notification means only that something was sent since the last one was
+// taken, not that a message is still buffered. Every call returns the same
+// channel, which is no longer notified once this receiver closes.
+func (me *Receiver[T]) NotifyOnActivity() <-chan struct{} {
+	me.shared.inner.Lock()
+	defer me.shared.inner.Unlock()
+	if me.activity == nil {
+		me.activity = make(chan struct{}, 1)
+		if !me.is_closed {
+			me.shared.inner.activity = append(me.shared.inner.activity, me.activity)
+		}
+	}
+	return me.activity
+}
+
+// Stats holds counters for tuning a channel.
+type Stats struct {
+	// LockContentions counts lock acquisitions that had to wait, on a
+	// channel from NewInstrumentedChannel. It is always zero otherwise.
+	LockContentions uint64
+	// SendBlocks counts sends that had to wait for room in a bounded
+	// channel, and SendBlockedTime is the total time they spent waiting.
+	SendBlocks      uint64
+	SendBlockedTime time.Duration
+}
+
+func (me *Receiver[T]) Stats() Stats {
+	me.shared.inner.Lock()
+	defer me.shared.inner.Unlock()
+	return Stats{
+		LockContentions: me.shared.inner.n_contentions,
+		SendBlocks:      me.shared.inner.n_send_blocks,
+		SendBlockedTime: me.shared.inner.send_blocked_time,
+	}
+}
+
+// ActiveSenders returns the tags of type K of every sender from CloneTagged
+// that has sent at least one message on rx's channel, whether or not it has
+// closed since, in the order they first sent. Tags of other types are left
+// out.
+func ActiveSenders[K comparable, T any](rx *Receiver[T]) []K {
+	rx.shared.inner.Lock()
+	defer rx.shared.inner.Unlock()
+	tags := []K{}
+	for _, tag := range rx.shared.inner.tag_order {
+		if tag, ok := tag.(K); ok {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// LatencyPercentiles returns the 50th, 95th and 99th percentiles of the time
+// messages on a channel from NewLatencyTrackedChannel spent between being
+// sent and received, over the last 1024 messages received. It returns zeros
+// if nothing has been received or the channel is not latency-tracked.
+func (me *Receiver[T]) LatencyPercentiles() (p50, p95, p99 time.Duration) {
+	me.shared.inner.Lock()
+	samples := slices.Clone(me.shared.inner.latencies)
+	me.shared.inner.Unlock()
+	if len(samples) == 0 {
+		return 0, 0, 0
+	}
+	slices.Sort(samples)
+	at := func(p int) time.Duration {
+		return samples[(len(samples)-1)*p/100]
+	}
+	return at(50), at(95), at(99)
+}
+
+// Count receives every message until the channel closes and returns how
+// many there were.
+func (me *Receiver[T]) Count() int {
+	n := 0
+	for _, ok := me.Recv(); ok; _, ok = me.Recv() {
+		n++
+	}
+	return n
+}
+
+// Any receives until a message satisfies pred, returning true, or the
+// channel closes, returning false. It consumes every message up to and
+// including the match.
+func (me *Receiver[T]) Any(pred func(T) bool) bool {
+	for msg, ok := me.Recv(); ok; msg, ok = me.Recv() {
+		if pred(msg) {
+			return true
+		}
+	}
+	return false
+}
+
+// All receives until a message fails pred, returning false, or the channel
+// closes, returning true. It consumes every message up to and including the
+// failing one.
+func (me *Receiver[T]) All(pred func(T) bool) bool {
+	return !me.Any(func(msg T) bool { return !pred(msg) })
+}
+
+// Len returns how many messages are buffered.
+func (me *Receiver[T]) Len() int {
+	me.shared.inner.Lock()
+	defer me.shared.inner.Unlock()
+	return me.shared.inner.length()
+}
+
+// ApproxLen is Len without taking the lock, for hot monitoring loops. It may
+// be momentarily off while sends and receives are in progress.
+func (me *Receiver[T]) ApproxLen() int {
+	return int(me.shared.inner.n_queued.Load())
+}
+
+// HealthStatus is a point-in-time report from Receiver.Health.
+type HealthStatus struct {
+	Open      bool
+	Backlog   int
+	Stalled   bool
+	Senders   int
+	Receivers int
+}
+
+// Health reports whether the channel is open, how many messages are buffered,
+// whether it looks stalled (a backlog but nothing received for longer than
+// stallAfter), and how many senders and non-weak receivers are open.
+func (me *Receiver[T]) Health(stallAfter time.Duration) HealthStatus {
+	me.shared.inner.Lock()
+	defer me.shared.inner.Unlock()
+	backlog := me.shared.inner.length()
+	return HealthStatus{
+		Open:      !me.shared.inner.closed(),
+		Backlog:   backlog,
+		Stalled:   backlog > 0 && time.Since(me.shared.inner.last_recv) > stallAfter,
+		Senders:   int(me.shared.inner.n_senders),
+		Receivers: int(me.shared.inner.n_receivers),
+	}
+}
+
+// Iterator is a stateful, pull-based view of a receiver. See
+// Receiver.Iterator.
+type Iterator[T any] struct {
+	rx      *Receiver[T]
+	stop    chan struct{}
+	stopped sync.Once
+}
+
+// Iterator returns an iterator receiving from a clone of this receiver. Stop
+// the iterator to close the clone once it is no longer needed.
+func (me *Receiver[T]) Iterator() *Iterator[T] {
+	return &Iterator[T]{rx: me.Clone(), stop: make(chan struct{})}
+}
+
+// Next receives the next message, returning false once the channel has
+// closed or the iterator has been stopped, including by a Stop from another
+// goroutine while Next waits.
+func (me *Iterator[T]) Next() (T, bool) {
+	msg, ok, stopped := me.rx.RecvCancel(me.stop)
+	if stopped {
+		return *new(T), false
+	}
+	return msg, ok
+}
+
+// Stop closes the iterator's receiver, waking a Next that is waiting.
+// Stopping twice has no further effect.
+func (me *Iterator[T]) Stop() {
+	me.stopped.Do(func() {
+		close(me.stop)
+		me.rx.Close()
+	})
+}
+
+// RecvPtr is Recv returning a pointer to the message in the channel's buffer
+// instead of a copy, for large message types. The pointer is nil once the
+// channel is closed. The popped slot is never reused, so the pointer stays
+// valid and is the receiver's to use, but it aliases the channel's buffer:
+// holding on to it keeps that whole buffer from being garbage collected.
+// Copy the message out if it is to be kept for long.
+func (me *Receiver[T]) RecvPtr() (*T, bool) {
+	announced := false
+	me.shared.inner.Lock()
+	for {
+		if me.ready() {
+			msg := me.shared.inner.pop_ptr()
+			me.shared.inner.Unlock()
+			return msg, true
+		}
+		if me.ended() {
+			me.shared.inner.Unlock()
+			return nil, false
+		}
+		me.wait(&announced)
+	}
+}
+
+// History returns the messages sent on a recording channel, oldest first,
+// whether or not they have been received. It is nil for other channels.
+func (me *Receiver[T]) History() []T {
+	me.shared.inner.Lock()
+	defer me.shared.inner.Unlock()
+	return append([]T(nil), me.shared.inner.history...)
+}
+
+// RecvType receives one message and passes it to the handler registered for
+// its dynamic type, if any; messages of other types are discarded. It
+// returns false, without calling a handler, once the channel has closed.
+// Go methods cannot specialize a type parameter, so this is a function over
+// Receiver[any] rather than a method.
+func RecvType(rx *Receiver[any], handlers map[reflect.Type]func(any)) bool {
+	msg, ok := rx.Recv()
+	if !ok {
+		return false
+	}
+	if handle, found := handlers[reflect.TypeOf(msg)]; found {
+		handle(msg)
+	}
+	return true
+}
+
+// RecvInto blocks for at least one message, then copies as many buffered
+// messages as fit into buf, returning how many it copied. Unlike RecvBurst it
+// allocates nothing, so a hot consumer can reuse one buffer.
+func (me *Receiver[T]) RecvInto(buf []T) (int, bool) {
+	announced := false
+	me.shared.inner.Lock()
+	for {
+		if me.ready() {
+			n := 0
+			for n < len(buf) && me.shared.inner.has_next() {
+				buf[n] = me.shared.inner.pop()
+				n++
+			}
+			me.shared.inner.Unlock()
+			return n, true
+		}
+		if me.ended() {
+			me.shared.inner.Unlock()
+			return 0, false
+		}
+		me.wait(&announced)
+	}
+}
+
+// RecvWithDepth is Recv, also returning how many messages were still
+// buffered right after the pop.
+func (me *Receiver[T]) RecvWithDepth() (msg T, depthAfter int, ok bool) {
+	announced := false
+	me.shared.inner.Lock()
+	defer me.shared.inner.Unlock()
+	for {
+		if me.ready() {
+			msg := me.shared.inner.pop()
+			return msg, me.shared.inner.length(), true
+		}
+		if me.ended() {
+			return *new(T), 0, false
+		}
+		me.wait(&announced)
+	}
+}
+
+// RecvUntil receives messages until one satisfies isBoundary, returning them
+// as a group. If inclusive the boundary message ends the group; otherwise it
+// is left queued to start the next one. A group cut off by the channel
+// closing is still returned, and ok is false only once nothing is left. With
+// several receivers, messages taken by the others while this one waits are
+// missing from its group.
+func (me *Receiver[T]) RecvUntil(isBoundary func(T) bool, inclusive bool) (group []T, ok bool) {
+	announced := false
+	me.shared.inner.Lock()
+	defer me.shared.inner.Unlock()
+	for {
+		if me.ready() {
+			head := me.shared.inner.peek()
+			if isBoundary(head) {
+				if inclusive {
+					return append(group, me.shared.inner.pop()), true
+				}
+				if len(group) > 0 {
+					return group, true
+				}
+			}
+			group = append(group, me.shared.inner.pop())
+			continue
+		}
+		if me.ended() {
+			return group, len(group) > 0
+		}
+		me.wait(&announced)
+	}
+}
+
+// RecvWithIdleFlag is Recv, also reporting whether more than idle passed
+// since this receiver's previous RecvWithIdleFlag, which marks the first
+// message of a new burst. The first message it ever receives is flagged too.
+func (me *Receiver[T]) RecvWithIdleFlag(idle time.Duration) (msg T, firstAfterIdle bool, ok bool) {
+	msg, ok = me.Recv()
+	if !ok {
+		return msg, false, false
+	}
+	now := time.Now()
+	firstAfterIdle = me.last_recv.IsZero() || now.Sub(me.last_recv) > idle
+	me.last_recv = now
+	return msg, firstAfterIdle, true
+}
+
+// RecvFiltered receives the first message satisfying pred, discarding every
+// message before it, without the goroutine and channel the Filter combinator
+// costs. With several receivers the discarded messages are lost to the
+// others too, so use it only where no other receiver wants them.
+func (me *Receiver[T]) RecvFiltered(pred func(T) bool) (T, bool) {
+	announced := false
+	me.shared.inner.Lock()
+	defer me.shared.inner.Unlock()
+	for {
+		for me.ready() {
+			msg := me.shared.inner.pop()
+			if pred(msg) {
+				return msg, true
+			}
+		}
+		if me.ended() {
+			return *new(T), false
+		}
+		me.wait(&announced)
+	}
+}
+
+// recv_when_poll is how often RecvWhen re-evaluates its condition while a
+// message is waiting, since nothing signals the channel when it changes.
+const recv_when_poll = time.Millisecond
+
+// RecvWhen is Recv that only takes a message while cond reports true. cond is
+// evaluated under the channel's lock whenever a message is available, and
+// re-evaluated every millisecond while one stays available, so it must be
+// cheap and must not use the channel. Meanwhile other receivers are free to
+// take the message. See RecvWhenCancel to give up waiting.
+func (me *Receiver[T]) RecvWhen(cond func() bool) (T, bool) {
+	msg, ok, _ := me.RecvWhenCancel(cond, nil)
+	return msg, ok
+}
+
+// RecvWhenCancel is RecvWhen that gives up once cancel is closed or sent a
+// value. A cancelled receive returns ok false and cancelled true; a closed
+// channel returns both false.
+func (me *Receiver[T]) RecvWhenCancel(cond func() bool, cancel <-chan struct{}) (msg T, ok bool, cancelled bool) {
+	var done, exited chan struct{}
+	announced := false
+	// fired is set under the lock if the watcher took a value sent on cancel.
+	fired := false
+	me.shared.inner.Lock()
+	me.shared.inner.n_gated += 1
+	defer func() {
+		me.shared.inner.Lock()
+		me.shared.inner.n_gated -= 1
+		me.shared.inner.Unlock()
+	}()
+	for {
+		if !fired {
+			select {
+			case <-cancel:
+				fired = true
+			default:
+			}
+		}
+		if fired {
+			me.shared.inner.Unlock()
+			if done != nil {
+				close(done)
+			}
+			return msg, false, true
+		}
+		if done != nil && (me.ready() || me.ended()) {
+			// As in recv_cancel, stop the watcher and look again before
+			// returning, so a value it took from cancel is not swallowed.
+			me.shared.inner.Unlock()
+			close(done)
+			<-exited
+			done = nil
+			me.shared.inner.Lock()
+			continue
+		}
+		if me.ready() {
+			if cond() {
+				msg = me.shared.inner.pop()
+				me.shared.inner.Unlock()
+				return msg, true, false
+			}
+			me.shared.inner.Unlock()
+			select {
+			case <-cancel:
+				me.shared.inner.Lock()
+				fired = true
+			case <-time.After(recv_when_poll):
+				me.shared.inner.Lock()
+			}
+			continue
+		}
+		if me.ended() {
+			me.shared.inner.Unlock()
+			return msg, false, false
+		}
+		if done == nil {
+			done = make(chan struct{})
+			exited = make(chan struct{})
+			go func(done chan struct{}, exited chan struct{}) {
+				defer close(exited)
+				select {
+				case <-cancel:
+					me.shared.inner.Lock()
+					fired = true
+					me.shared.inner.Unlock()
+					me.shared.available.Broadcast()
+				case <-done:
+				}
+			}(done, exited)
+		}
+		me.wait(&announced)
+	}
+}
+
+// RecvTx is Recv for transactional consumers: the message is taken off the
+// channel, but calling rollback puts it back at the front, to be received
+// next, for when processing it fails. Calling commit instead makes the
+// receive final. Only the first of commit and rollback has any effect, and a
+// message that is never rolled back stays received. A rolled back message
+// keeps its TTL, priority and send time. Rollback drops the message instead
+// once the channel has ended early or a receiver has seen it closed, since
+// no message may be received after that. With a custom Queue the message is
+// pushed back and the Queue decides where it goes.
+func (me *Receiver[T]) RecvTx() (msg T, commit func(), rollback func(), ok bool) {
+	announced := false
+	me.shared.inner.Lock()
+	for {
+		if me.ready() {
+			break
+		}
+		if me.ended() {
+			me.shared.inner.Unlock()
+			return *new(T), func() {}, func() {}, false
+		}
+		me.wait(&announced)
+	}
+	it := me.shared.inner.pop_item()
+	me.shared.inner.Unlock()
+
+	var once sync.Once
+	commit = func() { once.Do(func() {}) }
+	rollback = func() {
+		once.Do(func() {
+			me.shared.inner.Lock()
+			requeued := me.shared.inner.unpop(it)
+			me.shared.inner.Unlock()
+			if requeued {
+				me.shared.available.Broadcast()
+			}
+		})
+	}
+	return it.msg, commit, rollback, true
+}
+
+// RecvMerged receives a message as Recv does, then merges into it each
+// following buffered message for which mergeable(merged, next) is true,
+// using merge(merged, next), for coalescing runs of related messages. It
+// stops at the first message that is not mergeable, leaving it queued, or
+// once nothing more is buffered; it never waits for more to arrive.
+func (me *Receiver[T]) RecvMerged(mergeable func(a, b T) bool, merge func(a, b T) T) (T, bool) {
+	msg, ok := me.Recv()
+	if !ok {
+		return msg, false
+	}
+	me.shared.inner.Lock()
+	defer me.shared.inner.Unlock()
+	for me.ready() && mergeable(msg, me.shared.inner.peek()) {
+		msg = merge(msg, me.shared.inner.pop())
+	}
+	return msg, true
+}
+
+// TryRecv receives a message if one is ready, without waiting. It returns
+// Idle if none is, and Closed once none ever will be.
+func (me *Receiver[T]) TryRecv() (T, Status) {
+	msg, ok, closed := me.try_recv()
+	switch {
+	case ok:
+		return msg, Delivered
+	case closed:
+		return msg, Closed
+	default:
+		return msg, Idle
+	}
+}
+
+// ReadyChan returns a channel that receives a value whenever a message is
+// sent or the channel closes, for waiting on this receiver in a native select
+// and then taking messages with TryRecv. Signals are coalesced, so after one
+// arrives keep calling TryRecv until it returns Idle. If a message is already
+// buffered, or the channel has closed, the returned channel starts signalled.
+// Every call returns the same channel, which is no longer signalled once
+// this receiver closes.
+func (me *Receiver[T]) ReadyChan() <-chan struct{} {
+	me.shared.inner.Lock()
+	defer me.shared.inner.Unlock()
+	if me.ready_ch == nil {
+		me.ready_ch = make(chan struct{}, 1)
+		if !me.is_closed {
+			me.shared.inner.watch(me.ready_ch)
+		}
+	}
+	if me.ready() || me.shared.inner.closed() {
+		select {
+		case me.ready_ch <- struct{}{}:
+		default:
+		}
+	}
+	return me.ready_ch
+}
+
+// Chan returns a native channel carrying this receiver's messages, fed by a
+// goroutine, so that the receiver works with for-range and select:
+//
+//	for msg := range rx.Chan() {
+//		...
+//	}
+//
+// The channel closes once this channel has closed and been drained, or once
+// StopChan is called. Calling Chan again returns the same channel.
+func (me *Receiver[T]) Chan() <-chan T {
+	me.shared.inner.Lock()
+	defer me.shared.inner.Unlock()
+	if me.pump != nil {
+		return me.pump
+	}
+	me.pump = make(chan T)
+	me.stop = make(chan struct{})
+	pump, stop := me.pump, me.stop
+	go_stage(func() {
+		defer close(pump)
+		for {
+			it, ok, _ := me.recv_cancel(stop)
+			if !ok {
+				return
+			}
+			select {
+			case pump <- it.msg:
+			case <-stop:
+				// Put back the message nobody took.
+				me.shared.inner.Lock()
+				requeued := me.shared.inner.unpop(it)
+				me.shared.inner.Unlock()
+				if requeued {
+					me.shared.available.Broadcast()
+				}
+				return
+			}
+		}
+	})
+	return pump
+}
+
+// StopChan ends the goroutine feeding Chan, closing its channel, for leaving
+// a for-range over it early without leaking the goroutine. A message the
+// goroutine had already received but not yet handed over is put back at the
+// front of the channel. The receiver itself stays open. StopChan does nothing
+// if Chan was never called.
+func (me *Receiver[T]) StopChan() {
+	me.shared.inner.Lock()
+	defer me.shared.inner.Unlock()
+	if me.stop == nil {
+		return
+	}
+	select {
+	case <-me.stop:
+	default:
+		close(me.stop)
+	}
+}
+
+// try_recv receives a message if one is ready, without waiting. closed
+// reports that none ever will be.
+func (me *Receiver[T]) try_recv() (msg T, ok bool, closed bool) {
+	me.shared.inner.Lock()
+	defer me.shared.inner.Unlock()
+	if me.ready() {
+		return me.shared.inner.pop(), true, false
 	}
+	return *new(T), false, me.ended()
 }
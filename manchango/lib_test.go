@@ -1,9 +1,16 @@
 package manchan
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"math/rand"
 	"reflect"
+	"runtime"
+	"slices"
 	"sort"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -241,3 +248,1727 @@ func TestChannelMpmc(t *testing.T) {
 	_, ok = rx2.Recv(); if ok { t.FailNow() }
 	_, ok = rx3.Recv(); if ok { t.FailNow() }
 }
+
+func TestChannelWeakReceiver(t *testing.T) {
+	tx, rx := NewChannel[int]()
+	weak := rx.CloneWeak()
+	if !weak.is_weak || rx.is_weak { t.FailNow() }
+
+	tx.Send(1)
+	tx.Send(2)
+	tx.Close()
+
+	msg, ok := weak.Recv(); if !ok || msg != 1 { t.FailNow() }
+	msg, ok = rx.Recv(); if !ok || msg != 2 { t.FailNow() }
+	_, ok = weak.Recv(); if ok { t.FailNow() }
+	_, ok = rx.Recv(); if ok { t.FailNow() }
+}
+
+func TestChannelDeadline(t *testing.T) {
+	tx, rx := NewChannelWithDeadline[int](time.Now().Add(50 * time.Millisecond))
+	tx.Send(1)
+
+	start := time.Now()
+	msg, ok := rx.Recv(); if !ok || msg != 1 { t.FailNow() }
+	_, ok = rx.Recv(); if ok { t.FailNow() }
+	if time.Since(start) < 40*time.Millisecond { t.FailNow() }
+
+	tx.Send(2)
+	_, ok = rx.Recv(); if ok { t.FailNow() }
+}
+
+func TestChannelDeadlineStoppedOnClose(t *testing.T) {
+	tx, rx := NewChannelWithDeadline[int](time.Now().Add(time.Hour))
+	if rx.shared.inner.deadline == nil { t.FailNow() }
+	tx.Close()
+	if rx.shared.inner.deadline != nil { t.FailNow() }
+}
+
+func TestChannelDeadlineDiscard(t *testing.T) {
+	tx, rx := NewChannelWithDeadlineDiscard[int](time.Now().Add(20 * time.Millisecond))
+	tx.Send(1)
+	tx.Send(2)
+	time.Sleep(50 * time.Millisecond)
+	_, ok := rx.Recv(); if ok { t.FailNow() }
+}
+
+func TestChannelRecvTimed(t *testing.T) {
+	tx, rx := NewChannel[int]()
+	tx.Send(1)
+	_, ok, waited := rx.RecvTimed(); if !ok || waited != 0 { t.FailNow() }
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		tx.Send(2)
+	}()
+	msg, ok, waited := rx.RecvTimed(); if !ok || msg != 2 { t.FailNow() }
+	if waited < 40*time.Millisecond || waited > 500*time.Millisecond { t.FailNow() }
+}
+
+func TestChannelCloseRace(t *testing.T) {
+	const nSenders, nMsgs = 50, 200
+	for round := 0; round < 10; round++ {
+		tx, rx := NewChannel[int]()
+		senders := []*Sender[int]{}
+		for i := 0; i < nSenders; i++ {
+			senders = append(senders, tx.Clone())
+		}
+		tx.Close()
+		tx.Close()
+
+		for _, s := range senders {
+			go func(s *Sender[int]) {
+				for i := 0; i < nMsgs; i++ {
+					s.Send(i)
+				}
+				s.Close()
+			}(s)
+		}
+
+		count := 0
+		for _, ok := rx.Recv(); ok; _, ok = rx.Recv() {
+			count++
+		}
+		if count != nSenders*nMsgs { t.FailNow() }
+		_, ok := rx.Recv(); if ok { t.FailNow() }
+		if len(rx.shared.inner.queue) != 0 || rx.shared.inner.n_senders != 0 { t.FailNow() }
+	}
+}
+
+func TestChannelCloneCloseRace(t *testing.T) {
+	const nGoroutines, nClones = 50, 100
+	for round := 0; round < 10; round++ {
+		tx, rx := NewChannel[int]()
+		var wg sync.WaitGroup
+		for i := 0; i < nGoroutines; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				s := tx.Clone()
+				for j := 0; j < nClones; j++ {
+					next := s.Clone()
+					next.Send(j)
+					s.Close()
+					s = next
+				}
+				s.Close()
+			}()
+		}
+		go func() {
+			wg.Wait()
+			tx.Close()
+		}()
+
+		count := 0
+		for _, ok := rx.Recv(); ok; _, ok = rx.Recv() {
+			count++
+		}
+		if count != nGoroutines*nClones { t.FailNow() }
+		if rx.shared.inner.n_senders != 0 { t.FailNow() }
+	}
+}
+
+func TestChannelCloneClosedPanics(t *testing.T) {
+	tx, rx := NewChannel[int]()
+	tx.Close()
+	func() {
+		defer func() {
+			if recover() == nil { t.FailNow() }
+		}()
+		tx.Clone()
+	}()
+	_, ok := rx.Recv(); if ok { t.FailNow() }
+}
+
+func TestChannelSendAfterClosePanics(t *testing.T) {
+	tx, _ := NewChannel[int]()
+	tx.Close()
+	defer func() {
+		if recover() == nil { t.FailNow() }
+	}()
+	tx.Send(1)
+}
+
+func TestChannelRecvContext(t *testing.T) {
+	tx, rx := NewChannel[int]()
+	tx.Send(1)
+	msg, ok, err := rx.RecvContext(context.Background()); if !ok || err != nil || msg != 1 { t.FailNow() }
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, ok, err = rx.RecvContext(ctx); if ok || err != context.DeadlineExceeded { t.FailNow() }
+
+	tx.Close()
+	_, ok, err = rx.RecvContext(context.Background()); if ok || err != nil { t.FailNow() }
+}
+
+func TestChannelAllContext(t *testing.T) {
+	tx, rx := NewChannel[int]()
+	ctx, cancel := context.WithCancel(context.Background())
+	tx.Send(0)
+	tx.Send(1)
+	tx.Send(2)
+
+	got := []int{}
+	done := make(chan struct{})
+	go func() {
+		for msg := range rx.AllContext(ctx) {
+			got = append(got, msg)
+			if msg == 2 {
+				cancel()
+			}
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.FailNow()
+	}
+	if !reflect.DeepEqual(got, []int{0, 1, 2}) { t.FailNow() }
+}
+
+func TestChannelTTL(t *testing.T) {
+	tx, rx := NewTTLChannel[string](20 * time.Millisecond)
+	tx.Send("stale")
+	time.Sleep(40 * time.Millisecond)
+	tx.Send("fresh")
+	tx.Close()
+
+	msg, ok := rx.Recv(); if !ok || msg != "fresh" { t.FailNow() }
+	_, ok = rx.Recv(); if ok { t.FailNow() }
+	if rx.Expired() != 1 { t.FailNow() }
+}
+
+func TestChannelRecvBurst(t *testing.T) {
+	tx, rx := NewChannel[int]()
+	for i := 0; i < 4; i++ {
+		tx.Send(i)
+	}
+	msgs, ok := rx.RecvBurst(); if !ok || !reflect.DeepEqual(msgs, []int{0, 1, 2, 3}) { t.FailNow() }
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		tx.Send(4)
+		tx.Close()
+	}()
+	msgs, ok = rx.RecvBurst(); if !ok || !reflect.DeepEqual(msgs, []int{4}) { t.FailNow() }
+	_, ok = rx.RecvBurst(); if ok { t.FailNow() }
+}
+
+func TestChannelSenderDone(t *testing.T) {
+	tx, _ := NewChannel[int]()
+	tx1 := tx.Clone()
+	tx2 := tx.Clone()
+	done := tx.Done()
+
+	isDone := func() bool {
+		select {
+		case <-done:
+			return true
+		default:
+			return false
+		}
+	}
+
+	tx.Close()
+	if isDone() { t.FailNow() }
+	tx1.Close()
+	if isDone() { t.FailNow() }
+	tx2.Close()
+	if !isDone() { t.FailNow() }
+}
+
+func TestChannelTiered(t *testing.T) {
+	type job struct {
+		prio int
+		name string
+	}
+	tx, rx := NewTieredChannel(func(j job) int { return j.prio }, map[int]int{0: 2, 1: 2})
+	tx.Send(job{0, "low 1"})
+	tx.Send(job{0, "low 2"})
+	tx.Send(job{0, "low 3"})
+	tx.Send(job{1, "high 1"})
+	tx.Send(job{1, "high 2"})
+	tx.Close()
+
+	results := []string{}
+	for msg, ok := rx.Recv(); ok; msg, ok = rx.Recv() {
+		results = append(results, msg.name)
+	}
+	if !reflect.DeepEqual(results, []string{"high 1", "high 2", "low 1", "low 2"}) { t.FailNow() }
+	if len(rx.shared.inner.tier_len) != 2 || rx.shared.inner.tier_len[0] != 0 || rx.shared.inner.tier_len[1] != 0 { t.FailNow() }
+}
+
+func TestChannelWaitForBacklog(t *testing.T) {
+	tx, rx := NewChannel[int]()
+	sent := make(chan int, 3)
+	go func() {
+		for i := 0; i < 3; i++ {
+			time.Sleep(10 * time.Millisecond)
+			sent <- i
+			tx.Send(i)
+		}
+	}()
+	rx.WaitForBacklog(3)
+	if len(sent) != 3 { t.FailNow() }
+	msgs, ok := rx.RecvBurst(); if !ok || len(msgs) != 3 { t.FailNow() }
+
+	tx.Close()
+	rx.WaitForBacklog(1)
+}
+
+func TestChannelReceiverClose(t *testing.T) {
+	tx, rx := NewChannel[int]()
+	rx1 := rx.Clone()
+	weak := rx.CloneWeak()
+	tx.Send(1)
+
+	rx.Close()
+	rx.Close()
+	select {
+	case <-tx.abandoned():
+		t.FailNow()
+	default:
+	}
+
+	rx1.Close()
+	<-tx.abandoned()
+	tx.Send(2)
+	_, ok := weak.Recv(); if ok { t.FailNow() }
+}
+
+func TestChannelBoundedReceiverClose(t *testing.T) {
+	tx, rx := NewBoundedChannel[int](1)
+	tx.Send(1)
+	blocked := tx.SendAsync(2)
+	sent := make(chan struct{})
+	go func() {
+		tx.Send(3)
+		close(sent)
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	rx.Close()
+	select {
+	case <-sent:
+	case <-time.After(time.Second):
+		t.FailNow()
+	}
+	if blocked.Wait() != ErrClosed { t.FailNow() }
+}
+
+func TestChannelRecvOrIdle(t *testing.T) {
+	tx, rx := NewChannel[int]()
+	tx.Send(1)
+	msg, status := rx.RecvOrIdle(time.Second); if status != Delivered || msg != 1 { t.FailNow() }
+
+	start := time.Now()
+	_, status = rx.RecvOrIdle(20 * time.Millisecond); if status != Idle { t.FailNow() }
+	if time.Since(start) < 20*time.Millisecond { t.FailNow() }
+
+	tx.Close()
+	_, status = rx.RecvOrIdle(time.Second); if status != Closed { t.FailNow() }
+}
+
+func TestChannelBounded(t *testing.T) {
+	tx, rx := NewBoundedChannel[int](2)
+	tx.Send(1)
+	tx.Send(2)
+
+	sent := make(chan struct{})
+	go func() {
+		tx.Send(3)
+		close(sent)
+	}()
+	select {
+	case <-sent:
+		t.FailNow()
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	msg, ok := rx.Recv(); if !ok || msg != 1 { t.FailNow() }
+	<-sent
+	msg, ok = rx.Recv(); if !ok || msg != 2 { t.FailNow() }
+	msg, ok = rx.Recv(); if !ok || msg != 3 { t.FailNow() }
+}
+
+func TestChannelDropOldest(t *testing.T) {
+	var dropped []int
+	tx, rx := NewDropOldestChannel[int](2, func(msg int) { dropped = append(dropped, msg) })
+	tx.Send(1)
+	tx.Send(2)
+	tx.Send(3)
+	if !reflect.DeepEqual(dropped, []int{1}) { t.FailNow() }
+	tx.Close()
+	msg, ok := rx.Recv(); if !ok || msg != 2 { t.FailNow() }
+	msg, ok = rx.Recv(); if !ok || msg != 3 { t.FailNow() }
+	_, ok = rx.Recv(); if ok { t.FailNow() }
+}
+
+func TestChannelDropNewest(t *testing.T) {
+	var dropped []int
+	tx, rx := NewDropNewestChannel[int](2, func(msg int) { dropped = append(dropped, msg) })
+	tx.Send(1)
+	tx.Send(2)
+	tx.Send(3)
+	if !reflect.DeepEqual(dropped, []int{3}) { t.FailNow() }
+	tx.Close()
+	msg, ok := rx.Recv(); if !ok || msg != 1 { t.FailNow() }
+	msg, ok = rx.Recv(); if !ok || msg != 2 { t.FailNow() }
+	_, ok = rx.Recv(); if ok { t.FailNow() }
+}
+
+func TestChannelByteBounded(t *testing.T) {
+	tx, rx := NewByteBoundedChannel[string](10, func(s string) int { return len(s) })
+	tx.Send("0123456")
+	tx.Send("789")
+
+	sent := make(chan struct{})
+	go func() {
+		tx.Send("abcdefghijklmnop")
+		close(sent)
+	}()
+	select {
+	case <-sent:
+		t.FailNow()
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	msg, ok := rx.Recv(); if !ok || msg != "0123456" { t.FailNow() }
+	select {
+	case <-sent:
+		t.FailNow()
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	msg, ok = rx.Recv(); if !ok || msg != "789" { t.FailNow() }
+	<-sent
+	msg, ok = rx.Recv(); if !ok || msg != "abcdefghijklmnop" { t.FailNow() }
+	if rx.shared.inner.size != 0 { t.FailNow() }
+}
+
+func TestChannelSendAsync(t *testing.T) {
+	tx, rx := NewBoundedChannel[int](1)
+	futures := []*Future{}
+	for i := 0; i < 5; i++ {
+		futures = append(futures, tx.SendAsync(i))
+	}
+
+	for i := 0; i < 5; i++ {
+		msg, ok := rx.Recv(); if !ok || msg != i { t.FailNow() }
+	}
+	for _, future := range futures {
+		if future.Wait() != nil { t.FailNow() }
+	}
+
+	tx.Close()
+	if tx.SendAsync(5).Wait() != ErrClosed { t.FailNow() }
+}
+
+func TestChannelSendAsyncThenClose(t *testing.T) {
+	tx, rx := NewBoundedChannel[int](1)
+	first := tx.SendAsync(1)
+	second := tx.SendAsync(2)
+	tx.Close()
+	msg, ok := rx.Recv(); if !ok || msg != 1 { t.FailNow() }
+	msg, ok = rx.Recv(); if !ok || msg != 2 { t.FailNow() }
+	_, ok = rx.Recv(); if ok { t.FailNow() }
+	if first.Wait() != nil || second.Wait() != nil { t.FailNow() }
+}
+
+func TestChannelWithCancel(t *testing.T) {
+	tx, rx, cancel := NewChannelWithCancel[int]()
+	tx.Send(1)
+	msg, ok := rx.Recv(); if !ok || msg != 1 { t.FailNow() }
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	_, ok = rx.Recv(); if ok { t.FailNow() }
+	if rx.Err() != ErrCanceled { t.FailNow() }
+
+	tx.Send(2)
+	cancel()
+	_, ok = rx.Recv(); if ok { t.FailNow() }
+}
+
+func TestChannelWithCancelDiscards(t *testing.T) {
+	tx, rx, cancel := NewChannelWithCancel[int]()
+	tx.Send(1)
+	cancel()
+	_, ok := rx.Recv(); if ok { t.FailNow() }
+}
+
+func TestChannelCompact(t *testing.T) {
+	tx, rx := NewChannel[int]()
+	for i := 0; i < 10; i++ {
+		tx.Send(i)
+	}
+	tx.Close()
+
+	if rx.Compact(func(i int) bool { return i%2 == 0 }) != 5 { t.FailNow() }
+	msgs, ok := rx.RecvBurst(); if !ok || !reflect.DeepEqual(msgs, []int{1, 3, 5, 7, 9}) { t.FailNow() }
+}
+
+func TestChannelProducersBlocked(t *testing.T) {
+	tx, rx := NewBoundedChannel[int](1)
+	tx.Send(0)
+	if rx.ProducersBlocked() != 0 { t.FailNow() }
+
+	tx1 := tx.Clone()
+	tx2 := tx.Clone()
+	go tx1.Send(1)
+	go tx2.Send(2)
+	for rx.ProducersBlocked() != 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	for i := 0; i < 3; i++ {
+		_, ok := rx.Recv(); if !ok { t.FailNow() }
+	}
+	if rx.ProducersBlocked() != 0 { t.FailNow() }
+}
+
+func TestTransfer(t *testing.T) {
+	srcTx, src := NewChannel[int]()
+	dstTx, dst := NewChannel[int]()
+	for i := 0; i < 5; i++ {
+		srcTx.Send(i)
+	}
+
+	if Transfer(src, dstTx, 3) != 3 { t.FailNow() }
+	msgs, ok := dst.RecvBurst(); if !ok || !reflect.DeepEqual(msgs, []int{0, 1, 2}) { t.FailNow() }
+	msgs, ok = src.RecvBurst(); if !ok || !reflect.DeepEqual(msgs, []int{3, 4}) { t.FailNow() }
+	if Transfer(src, dstTx, 3) != 0 { t.FailNow() }
+}
+
+func TestTransferRefused(t *testing.T) {
+	srcTx, src := NewChannel[int]()
+	for i := 0; i < 3; i++ {
+		srcTx.Send(i)
+	}
+
+	dstTx, dst := NewTieredChannel(func(int) int { return 0 }, map[int]int{0: 1})
+	if Transfer(src, dstTx, 3) != 1 { t.FailNow() }
+	if dst.Len() != 1 || src.Len() != 2 { t.FailNow() }
+
+	dst.Close()
+	if Transfer(src, dstTx, 3) != 0 { t.FailNow() }
+	msgs, ok := src.RecvBurst(); if !ok || !reflect.DeepEqual(msgs, []int{1, 2}) { t.FailNow() }
+}
+
+func TestChannelRecvWithFallback(t *testing.T) {
+	tx, rx := NewChannel[int]()
+	fallbackTx, fallback := NewChannel[int]()
+	for i := 0; i < 3; i++ {
+		tx.Send(i)
+	}
+	tx.Close()
+	fallbackTx.Send(3)
+	fallbackTx.Send(4)
+	fallbackTx.Close()
+
+	for i := 0; i < 5; i++ {
+		msg, ok := rx.RecvWithFallback(fallback); if !ok || msg != i { t.FailNow() }
+	}
+	_, ok := rx.RecvWithFallback(fallback); if ok { t.FailNow() }
+}
+
+// injectSpuriousWakeups keeps waking every goroutine blocked on the channel
+// without changing its state, until the returned function is called.
+func injectSpuriousWakeups[T any](shared *Shared[T]) func() {
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			shared.inner.Lock()
+			shared.inner.Unlock()
+			shared.wake_all()
+			time.Sleep(100 * time.Microsecond)
+		}
+	}()
+	return func() {
+		close(stop)
+		<-stopped
+	}
+}
+
+func TestChannelSpuriousWakeups(t *testing.T) {
+	tx, rx := NewBoundedChannel[int](1)
+	stop := injectSpuriousWakeups(tx.shared)
+	defer stop()
+
+	expectBlocked := func(name string, op func()) chan struct{} {
+		done := make(chan struct{})
+		go func() {
+			op()
+			close(done)
+		}()
+		select {
+		case <-done:
+			t.Fatalf("%s returned on a spurious wakeup", name)
+		case <-time.After(20 * time.Millisecond):
+		}
+		return done
+	}
+
+	done := expectBlocked("Recv", func() {
+		if msg, ok := rx.Recv(); !ok || msg != 1 { t.Error("Recv") }
+	})
+	tx.Send(1)
+	<-done
+
+	done = expectBlocked("RecvBurst", func() {
+		if msgs, ok := rx.RecvBurst(); !ok || len(msgs) != 1 { t.Error("RecvBurst") }
+	})
+	tx.Send(2)
+	<-done
+
+	done = expectBlocked("RecvTimed", func() {
+		if _, ok, _ := rx.RecvTimed(); !ok { t.Error("RecvTimed") }
+	})
+	tx.Send(3)
+	<-done
+
+	done = expectBlocked("RecvContext", func() {
+		if _, ok, err := rx.RecvContext(context.Background()); !ok || err != nil { t.Error("RecvContext") }
+	})
+	tx.Send(4)
+	<-done
+
+	done = expectBlocked("RecvOrIdle", func() {
+		if _, status := rx.RecvOrIdle(time.Hour); status != Delivered { t.Error("RecvOrIdle") }
+	})
+	tx.Send(5)
+	<-done
+
+	tx.Send(6)
+	done = expectBlocked("bounded Send", func() { tx.Send(7) })
+	msg, ok := rx.Recv(); if !ok || msg != 6 { t.FailNow() }
+	<-done
+	msg, ok = rx.Recv(); if !ok || msg != 7 { t.FailNow() }
+
+	done = expectBlocked("WaitForBacklog", func() { rx.WaitForBacklog(1) })
+	tx.Send(8)
+	<-done
+
+	tx.Close()
+	msg, ok = rx.Recv(); if !ok || msg != 8 { t.FailNow() }
+	_, ok = rx.Recv(); if ok { t.FailNow() }
+}
+
+func TestChannelCloseAndDrain(t *testing.T) {
+	tx, rx := NewChannel[int]()
+	tx1 := tx.Clone()
+	for i := 0; i < 3; i++ {
+		tx.Send(i)
+	}
+
+	if tx.CloseAndDrain() != nil { t.FailNow() }
+	if !reflect.DeepEqual(tx1.CloseAndDrain(), []int{0, 1, 2}) { t.FailNow() }
+	_, ok := rx.Recv(); if ok { t.FailNow() }
+}
+
+func TestChannelHealth(t *testing.T) {
+	stallAfter := 20 * time.Millisecond
+
+	tx, rx := NewChannel[int]()
+	rx.Clone()
+	if rx.Health(stallAfter) != (HealthStatus{Open: true, Senders: 1, Receivers: 2}) { t.FailNow() }
+
+	tx.Send(1)
+	if rx.Health(stallAfter).Stalled { t.FailNow() }
+	time.Sleep(40 * time.Millisecond)
+	if rx.Health(stallAfter) != (HealthStatus{Open: true, Backlog: 1, Stalled: true, Senders: 1, Receivers: 2}) { t.FailNow() }
+
+	rx.Recv()
+	tx.Send(2)
+	if rx.Health(stallAfter).Stalled { t.FailNow() }
+
+	tx.Close()
+	if rx.Health(stallAfter).Open { t.FailNow() }
+}
+
+func TestChannelFail(t *testing.T) {
+	boom := errors.New("boom")
+	tx, rx := NewChannel[int]()
+	tx.Send(1)
+	tx.Send(2)
+	tx.Fail(boom)
+
+	msg, ok := rx.Recv(); if !ok || msg != 1 || rx.Err() != nil { t.FailNow() }
+	msg, ok = rx.Recv(); if !ok || msg != 2 { t.FailNow() }
+	_, ok = rx.Recv(); if ok { t.FailNow() }
+	if rx.Err() != boom { t.FailNow() }
+}
+
+func TestChannelFairBounded(t *testing.T) {
+	tx, rx := NewFairBoundedChannel[int](1)
+	tx.Send(0)
+	for i := 1; i <= 3; i++ {
+		sender := tx.Clone()
+		go sender.Send(i)
+		for rx.ProducersBlocked() != i {
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	for i := 0; i <= 3; i++ {
+		msg, ok := rx.Recv(); if !ok || msg != i { t.FailNow() }
+	}
+}
+
+func TestChannelIteratorObject(t *testing.T) {
+	tx, rx := NewChannel[int]()
+	for i := 0; i < 3; i++ {
+		tx.Send(i)
+	}
+	tx.Close()
+
+	it := rx.Iterator()
+	for i := 0; i < 3; i++ {
+		msg, ok := it.Next(); if !ok || msg != i { t.FailNow() }
+	}
+	_, ok := it.Next(); if ok { t.FailNow() }
+	it.Stop()
+	if rx.Health(time.Second).Receivers != 1 { t.FailNow() }
+}
+
+func TestChannelIteratorStop(t *testing.T) {
+	tx, rx := NewChannel[int]()
+	tx.Send(0)
+	it := rx.Iterator()
+	it.Stop()
+	_, ok := it.Next(); if ok { t.FailNow() }
+	msg, ok := rx.Recv(); if !ok || msg != 0 { t.FailNow() }
+}
+
+func TestChannelIteratorStopWakesNext(t *testing.T) {
+	_, rx := NewChannel[int]()
+	it := rx.Iterator()
+	done := make(chan bool)
+	blocked := make(chan struct{})
+	it.rx.OnBlock(func() { close(blocked) })
+	go func() {
+		_, ok := it.Next()
+		done <- ok
+	}()
+	<-blocked
+	it.Stop()
+	select {
+	case ok := <-done: if ok { t.FailNow() }
+	case <-time.After(5 * time.Second): t.FailNow()
+	}
+}
+
+type largeMessage struct {
+	id      int
+	payload [4096]byte
+}
+
+func TestChannelRecvPtr(t *testing.T) {
+	tx, rx := NewChannel[largeMessage]()
+	tx.Send(largeMessage{id: 1})
+	tx.Send(largeMessage{id: 2})
+	tx.Close()
+
+	first, ok := rx.RecvPtr(); if !ok || first.id != 1 { t.FailNow() }
+	second, ok := rx.RecvPtr(); if !ok || second.id != 2 || first.id != 1 { t.FailNow() }
+	msg, ok := rx.RecvPtr(); if ok || msg != nil { t.FailNow() }
+}
+
+func BenchmarkRecvLarge(b *testing.B) {
+	tx, rx := NewChannel[largeMessage]()
+	for i := 0; i < b.N; i++ {
+		tx.Send(largeMessage{id: i})
+		if msg, _ := rx.Recv(); msg.id != i { b.FailNow() }
+	}
+}
+
+func BenchmarkRecvPtrLarge(b *testing.B) {
+	tx, rx := NewChannel[largeMessage]()
+	for i := 0; i < b.N; i++ {
+		tx.Send(largeMessage{id: i})
+		if msg, _ := rx.RecvPtr(); msg.id != i { b.FailNow() }
+	}
+}
+
+func TestChannelRecording(t *testing.T) {
+	tx, rx := NewRecordingChannel[int](0)
+	for i := 0; i < 5; i++ {
+		tx.Send(i)
+		if i%2 == 0 {
+			rx.Recv()
+		}
+	}
+	if !reflect.DeepEqual(rx.History(), []int{0, 1, 2, 3, 4}) { t.FailNow() }
+
+	tx, rx = NewRecordingChannel[int](2)
+	for i := 0; i < 5; i++ {
+		tx.Send(i)
+	}
+	if !reflect.DeepEqual(rx.History(), []int{3, 4}) { t.FailNow() }
+}
+
+func TestChannelSendOrClose(t *testing.T) {
+	tx, rx := NewBoundedChannel[int](1)
+	tx1 := tx.Clone()
+	if !tx.SendOrClose(1) { t.FailNow() }
+	if tx.SendOrClose(2) { t.FailNow() }
+	if !tx.is_closed || rx.Health(time.Second).Senders != 1 { t.FailNow() }
+
+	msg, ok := rx.Recv(); if !ok || msg != 1 { t.FailNow() }
+	tx1.Close()
+	_, ok = rx.Recv(); if ok { t.FailNow() }
+}
+
+func TestRecvType(t *testing.T) {
+	tx, rx := NewChannel[any]()
+	tx.Send(1)
+	tx.Send("two")
+	tx.Send(3.0)
+	tx.Send(4)
+	tx.Close()
+
+	ints, strings := []int{}, []string{}
+	handlers := map[reflect.Type]func(any){
+		reflect.TypeOf(0):  func(msg any) { ints = append(ints, msg.(int)) },
+		reflect.TypeOf(""): func(msg any) { strings = append(strings, msg.(string)) },
+	}
+	for RecvType(rx, handlers) {
+	}
+	if !reflect.DeepEqual(ints, []int{1, 4}) { t.FailNow() }
+	if !reflect.DeepEqual(strings, []string{"two"}) { t.FailNow() }
+}
+
+func TestChannelRecvInto(t *testing.T) {
+	tx, rx := NewChannel[int]()
+	for i := 0; i < 5; i++ {
+		tx.Send(i)
+	}
+	tx.Close()
+
+	buf := make([]int, 3)
+	n, ok := rx.RecvInto(buf); if !ok || n != 3 || !reflect.DeepEqual(buf, []int{0, 1, 2}) { t.FailNow() }
+	n, ok = rx.RecvInto(buf); if !ok || n != 2 || !reflect.DeepEqual(buf[:n], []int{3, 4}) { t.FailNow() }
+	n, ok = rx.RecvInto(buf); if ok || n != 0 { t.FailNow() }
+}
+
+func TestRecvWithDepth(t *testing.T) {
+	tx, rx := NewChannel[int]()
+	tx.Send(1)
+	tx.Send(2)
+	tx.Send(3)
+	for i, want := range []int{2, 1, 0} {
+		msg, depth, ok := rx.RecvWithDepth()
+		if !ok || msg != i+1 || depth != want { t.FailNow() }
+	}
+	tx.Close()
+	_, _, ok := rx.RecvWithDepth(); if ok { t.FailNow() }
+}
+
+func TestChannelSendTrackedBounded(t *testing.T) {
+	tx, rx := NewChannel[int]()
+	first := tx.SendTrackedBounded(1, 2)
+	tx.SendTrackedBounded(2, 2)
+
+	sent := make(chan struct{})
+	go func() {
+		tx.SendTrackedBounded(3, 2)
+		close(sent)
+	}()
+	select {
+	case <-sent:
+		t.FailNow()
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	msg, ok := rx.Recv(); if !ok || msg != 1 { t.FailNow() }
+	<-first
+	<-sent
+	msg, ok = rx.Recv(); if !ok || msg != 2 { t.FailNow() }
+	msg, ok = rx.Recv(); if !ok || msg != 3 { t.FailNow() }
+}
+
+func TestChannelSendTrackedBoundedNonPositive(t *testing.T) {
+	tx, _ := NewChannel[int]()
+	defer func() {
+		if recover() == nil { t.FailNow() }
+	}()
+	tx.SendTrackedBounded(1, 0)
+}
+
+func TestChannelPause(t *testing.T) {
+	tx, rx := NewChannel[int]()
+	other := rx.Clone()
+	rx.Pause()
+	tx.Send(1)
+	tx.Send(2)
+
+	got := make(chan int)
+	go func() {
+		msg, _ := rx.Recv()
+		got <- msg
+	}()
+	select {
+	case <-got:
+		t.FailNow()
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	msg, ok := other.Recv(); if !ok || msg != 1 { t.FailNow() }
+	rx.Resume()
+	if <-got != 2 { t.FailNow() }
+}
+
+func TestChannelRecvCancel(t *testing.T) {
+	tx, rx := NewChannel[int]()
+	cancel := make(chan struct{})
+	go func() { time.Sleep(10 * time.Millisecond); close(cancel) }()
+	_, ok, cancelled := rx.RecvCancel(cancel); if ok || !cancelled { t.FailNow() }
+
+	tx.Send(1)
+	tx.Close()
+	msg, ok, cancelled := rx.RecvCancel(make(chan struct{})); if !ok || cancelled || msg != 1 { t.FailNow() }
+	_, ok, cancelled = rx.RecvCancel(make(chan struct{})); if ok || cancelled { t.FailNow() }
+}
+
+func TestChannelApproxLen(t *testing.T) {
+	tx, rx := NewChannel[int]()
+	for i := 0; i < 5; i++ { tx.Send(i) }
+	if rx.Len() != 5 || rx.ApproxLen() != 5 { t.FailNow() }
+	rx.Recv()
+	rx.Recv()
+	if rx.Len() != 3 || rx.ApproxLen() != 3 { t.FailNow() }
+	rx.Compact(func(msg int) bool { return msg == 3 })
+	if rx.Len() != 2 || rx.ApproxLen() != 2 { t.FailNow() }
+}
+
+func TestChannelNotifyOnActivity(t *testing.T) {
+	tx, rx := NewChannel[int]()
+	activity := rx.NotifyOnActivity()
+	select {
+	case <-activity:
+		t.FailNow()
+	default:
+	}
+	tx.Send(1)
+	tx.Send(2)
+	<-activity
+	select {
+	case <-activity:
+		t.FailNow()
+	default:
+	}
+	rx.Recv()
+	select {
+	case <-activity:
+		t.FailNow()
+	default:
+	}
+	tx.Send(3)
+	<-activity
+}
+
+func TestChannelNotifyOnActivityUnsubscribes(t *testing.T) {
+	_, rx := NewChannel[int]()
+	rx2 := rx.Clone()
+	if rx2.NotifyOnActivity() != rx2.NotifyOnActivity() { t.FailNow() }
+	if rx2.ReadyChan() != rx2.ReadyChan() { t.FailNow() }
+	rx.NotifyOnActivity()
+	rx.ReadyChan()
+	if len(rx.shared.inner.activity) != 2 || len(rx.shared.inner.watchers) != 2 { t.FailNow() }
+	rx2.Close()
+	if len(rx.shared.inner.activity) != 1 || len(rx.shared.inner.watchers) != 1 { t.FailNow() }
+}
+
+func TestChannelSendWithDeadline(t *testing.T) {
+	tx, rx := NewChannel[int]()
+	tx.SendWithDeadline(1, time.Now().Add(-time.Second))
+	tx.SendWithDeadline(2, time.Now().Add(time.Minute))
+	tx.Close()
+	msg, ok := rx.Recv(); if !ok || msg != 2 { t.FailNow() }
+	_, ok = rx.Recv(); if ok { t.FailNow() }
+	if rx.Expired() != 1 { t.FailNow() }
+}
+
+func TestCloneEndpoints(t *testing.T) {
+	tx, rx := NewChannel[int]()
+	tx2, rx2 := CloneEndpoints(tx, rx)
+	tx2.Send(1)
+	msg, ok := rx.Recv(); if !ok || msg != 1 { t.FailNow() }
+	tx.Send(2)
+	msg, ok = rx2.Recv(); if !ok || msg != 2 { t.FailNow() }
+	tx.Close()
+	tx2.Close()
+	_, ok = rx2.Recv(); if ok { t.FailNow() }
+}
+
+func TestChannelLockContentions(t *testing.T) {
+	tx, rx := NewInstrumentedChannel[int]()
+	for i := 0; i < 1000; i++ { tx.Send(i) }
+	for i := 0; i < 1000; i++ { rx.Recv() }
+	if rx.Stats().LockContentions != 0 { t.FailNow() }
+
+	// Hold the lock so that every sender has to wait for it.
+	var wg sync.WaitGroup
+	tx.shared.inner.Lock()
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tx.Send(i)
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+	tx.shared.inner.Unlock()
+	wg.Wait()
+	if rx.Stats().LockContentions < 8 { t.FailNow() }
+}
+
+func TestChannelRecvUntil(t *testing.T) {
+	isBoundary := func(msg int) bool { return msg%3 == 0 }
+	tx, rx := NewChannel[int]()
+	for i := 1; i <= 7; i++ { tx.Send(i) }
+	tx.Close()
+	group, ok := rx.RecvUntil(isBoundary, true); if !ok || !reflect.DeepEqual(group, []int{1, 2, 3}) { t.FailNow() }
+	group, ok = rx.RecvUntil(isBoundary, true); if !ok || !reflect.DeepEqual(group, []int{4, 5, 6}) { t.FailNow() }
+	group, ok = rx.RecvUntil(isBoundary, true); if !ok || !reflect.DeepEqual(group, []int{7}) { t.FailNow() }
+	_, ok = rx.RecvUntil(isBoundary, true); if ok { t.FailNow() }
+
+	tx, rx = NewChannel[int]()
+	for i := 1; i <= 7; i++ { tx.Send(i) }
+	tx.Close()
+	group, ok = rx.RecvUntil(isBoundary, false); if !ok || !reflect.DeepEqual(group, []int{1, 2}) { t.FailNow() }
+	group, ok = rx.RecvUntil(isBoundary, false); if !ok || !reflect.DeepEqual(group, []int{3, 4, 5}) { t.FailNow() }
+	group, ok = rx.RecvUntil(isBoundary, false); if !ok || !reflect.DeepEqual(group, []int{6, 7}) { t.FailNow() }
+	_, ok = rx.RecvUntil(isBoundary, false); if ok { t.FailNow() }
+}
+
+func TestChannelSenderTransfer(t *testing.T) {
+	tx, rx := NewChannel[int]()
+	next := tx.Transfer()
+	func() {
+		defer func() {
+			if recover() == nil { t.FailNow() }
+		}()
+		tx.Send(1)
+	}()
+	func() {
+		defer func() {
+			if recover() == nil { t.FailNow() }
+		}()
+		tx.Close()
+	}()
+	next.Send(2)
+	next.Close()
+	msg, ok := rx.Recv(); if !ok || msg != 2 { t.FailNow() }
+	_, ok = rx.Recv(); if ok { t.FailNow() }
+}
+
+func TestChannelStatsSendBlocks(t *testing.T) {
+	tx, rx := NewBoundedChannel[int](1)
+	go func() {
+		for i := 0; i < 4; i++ { tx.Send(i) }
+		tx.Close()
+	}()
+	for _, ok := rx.Recv(); ok; _, ok = rx.Recv() {
+		time.Sleep(5 * time.Millisecond)
+	}
+	stats := rx.Stats()
+	if stats.SendBlocks < 2 || stats.SendBlockedTime == 0 { t.FailNow() }
+	if stats.LockContentions != 0 { t.FailNow() }
+}
+
+func TestChannelConsume(t *testing.T) {
+	tx, rx := NewChannel[int]()
+	for i := 1; i <= 5; i++ { tx.Send(i) }
+	tx.Close()
+	errBad := errors.New("bad")
+	var got []int
+	err := rx.Consume(context.Background(), func(msg int) error {
+		got = append(got, msg)
+		if msg == 3 { return errBad }
+		return nil
+	})
+	if err != errBad || !reflect.DeepEqual(got, []int{1, 2, 3}) { t.FailNow() }
+	err = rx.Consume(context.Background(), func(msg int) error { return nil })
+	if err != nil { t.FailNow() }
+
+	_, rx = NewChannel[int]()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = rx.Consume(ctx, func(msg int) error { return nil })
+	if err != context.Canceled { t.FailNow() }
+}
+
+func TestChannelRecvWhen(t *testing.T) {
+	tx, rx := NewChannel[int]()
+	var open atomic.Bool
+	tx.Send(1)
+	got := make(chan int)
+	go func() {
+		msg, _ := rx.RecvWhen(open.Load)
+		got <- msg
+	}()
+	select {
+	case <-got:
+		t.FailNow()
+	case <-time.After(20 * time.Millisecond):
+	}
+	open.Store(true)
+	if <-got != 1 { t.FailNow() }
+	tx.Close()
+	_, ok := rx.RecvWhen(open.Load); if ok { t.FailNow() }
+}
+
+func TestChannelRecvWhenCancel(t *testing.T) {
+	tx, rx := NewChannel[int]()
+	cancel := make(chan struct{})
+	close(cancel)
+	_, ok, cancelled := rx.RecvWhenCancel(func() bool { return true }, cancel); if ok || !cancelled { t.FailNow() }
+
+	closeSoon := func() chan struct{} {
+		cancel := make(chan struct{})
+		go func() { time.Sleep(5 * time.Millisecond); close(cancel) }()
+		return cancel
+	}
+	never := func() bool { return false }
+	tx.Send(1)
+	_, ok, cancelled = rx.RecvWhenCancel(never, closeSoon()); if ok || !cancelled { t.FailNow() }
+	msg, ok := rx.Recv(); if !ok || msg != 1 { t.FailNow() }
+	_, ok, cancelled = rx.RecvWhenCancel(never, closeSoon()); if ok || !cancelled { t.FailNow() }
+}
+
+func TestChannelRecvWhenCancelBeforeMessage(t *testing.T) {
+	tx, rx := NewChannel[int]()
+	always := func() bool { return true }
+	for i := 0; i < 100; i++ {
+		cancel := make(chan struct{})
+		go func() {
+			cancel <- struct{}{}
+			tx.Send(i)
+		}()
+		_, ok, cancelled := rx.RecvWhenCancel(always, cancel); if ok || !cancelled { t.FailNow() }
+		msg, ok := rx.Recv(); if !ok || msg != i { t.FailNow() }
+	}
+}
+
+func TestChannelUnbound(t *testing.T) {
+	tx, rx := NewBoundedChannel[int](1)
+	tx.Send(1)
+	sent := make(chan struct{})
+	go func() {
+		tx.Send(2)
+		close(sent)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	tx.Unbound()
+	<-sent
+	tx.Send(3)
+	if rx.Len() != 3 { t.FailNow() }
+
+	tx.Rebound(2)
+	sent = make(chan struct{})
+	go func() {
+		tx.Send(4)
+		close(sent)
+	}()
+	rx.Recv()
+	select {
+	case <-sent:
+		t.FailNow()
+	case <-time.After(20 * time.Millisecond):
+	}
+	rx.Recv()
+	<-sent
+	msg, ok := rx.Recv(); if !ok || msg != 3 { t.FailNow() }
+	msg, ok = rx.Recv(); if !ok || msg != 4 { t.FailNow() }
+}
+
+func TestChannelReboundNegative(t *testing.T) {
+	tx, _ := NewBoundedChannel[int](1)
+	defer func() {
+		if recover() == nil { t.FailNow() }
+	}()
+	tx.Rebound(-1)
+}
+
+func TestChannelRecvWithIdleFlag(t *testing.T) {
+	tx, rx := NewChannel[int]()
+	tx.Send(1)
+	tx.Send(2)
+	go func() {
+		time.Sleep(30 * time.Millisecond)
+		tx.Send(3)
+		tx.Send(4)
+		tx.Close()
+	}()
+	for _, want := range []bool{true, false, true, false} {
+		_, first, ok := rx.RecvWithIdleFlag(15 * time.Millisecond)
+		if !ok || first != want { t.FailNow() }
+	}
+	_, _, ok := rx.RecvWithIdleFlag(15 * time.Millisecond); if ok { t.FailNow() }
+}
+
+func TestChannelWaitForEmpty(t *testing.T) {
+	tx, rx := NewChannel[int]()
+	tx.Send(1)
+	tx.Send(2)
+	start := time.Now()
+	if tx.WaitForEmptyTimeout(20 * time.Millisecond) { t.FailNow() }
+	if time.Since(start) < 20*time.Millisecond { t.FailNow() }
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		rx.Recv()
+		rx.Recv()
+	}()
+	tx.WaitForEmpty()
+	if rx.Len() != 0 { t.FailNow() }
+	if !tx.WaitForEmptyTimeout(time.Millisecond) { t.FailNow() }
+}
+
+func TestChannelRecvFiltered(t *testing.T) {
+	isEven := func(msg int) bool { return msg%2 == 0 }
+	tx, rx := NewChannel[int]()
+	for _, msg := range []int{1, 3, 4, 5, 6, 7} { tx.Send(msg) }
+	tx.Close()
+	msg, ok := rx.RecvFiltered(isEven); if !ok || msg != 4 { t.FailNow() }
+	msg, ok = rx.RecvFiltered(isEven); if !ok || msg != 6 { t.FailNow() }
+	_, ok = rx.RecvFiltered(isEven); if ok { t.FailNow() }
+	if rx.Len() != 0 { t.FailNow() }
+}
+
+func TestChannelOnBlock(t *testing.T) {
+	tx, rx := NewChannel[int]()
+	blocked := make(chan struct{}, 1)
+	rx.OnBlock(func() { blocked <- struct{}{} })
+	tx.Send(1)
+	msg, ok := rx.Recv(); if !ok || msg != 1 { t.FailNow() }
+	select {
+	case <-blocked:
+		t.FailNow()
+	default:
+	}
+
+	go func() {
+		<-blocked
+		tx.Send(2)
+	}()
+	msg, ok = rx.Recv(); if !ok || msg != 2 { t.FailNow() }
+}
+
+func TestChannelOnBlockAllReceives(t *testing.T) {
+	tx, rx := NewChannel[int]()
+	n := 0
+	rx.OnBlock(func() { n++; tx.Send(n) })
+	always := func(int) bool { return true }
+
+	msg, ok, _ := rx.RecvTimed(); if !ok || msg != 1 { t.FailNow() }
+	burst, ok := rx.RecvBurst(); if !ok || !reflect.DeepEqual(burst, []int{2}) { t.FailNow() }
+	msg, status := rx.RecvOrIdle(time.Second); if status != Delivered || msg != 3 { t.FailNow() }
+	ptr, ok := rx.RecvPtr(); if !ok || *ptr != 4 { t.FailNow() }
+	buf := make([]int, 4)
+	got, ok := rx.RecvInto(buf); if !ok || got != 1 || buf[0] != 5 { t.FailNow() }
+	msg, _, ok = rx.RecvWithDepth(); if !ok || msg != 6 { t.FailNow() }
+	group, ok := rx.RecvUntil(always, true); if !ok || !reflect.DeepEqual(group, []int{7}) { t.FailNow() }
+	msg, ok = rx.RecvFiltered(always); if !ok || msg != 8 { t.FailNow() }
+	msg, ok = rx.RecvWhen(func() bool { return true }); if !ok || msg != 9 { t.FailNow() }
+}
+
+func TestChannelActiveSenders(t *testing.T) {
+	tx, rx := NewChannel[int]()
+	a, b, c := CloneTagged(tx, "a"), CloneTagged(tx, "b"), CloneTagged(tx, "c")
+	c.Send(1)
+	a.Send(2)
+	c.Send(3)
+	b.Close()
+	tx.Send(4)
+	if !reflect.DeepEqual(ActiveSenders[string](rx), []string{"c", "a"}) { t.FailNow() }
+
+	type worker int
+	w := CloneTagged(tx, worker(7))
+	w.Send(5)
+	if !reflect.DeepEqual(ActiveSenders[worker](rx), []worker{7}) { t.FailNow() }
+}
+
+func TestChannelRecvInterruptible(t *testing.T) {
+	tx, rx := NewChannel[int]()
+	interrupt := make(chan struct{}, 1)
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		interrupt <- struct{}{}
+	}()
+	_, status, ok := rx.RecvInterruptible(interrupt); if ok || status != Interrupted { t.FailNow() }
+
+	tx.Send(1)
+	msg, status, ok := rx.RecvInterruptible(make(chan struct{})); if !ok || status != NotInterrupted || msg != 1 { t.FailNow() }
+	tx.Close()
+	_, status, ok = rx.RecvInterruptible(make(chan struct{})); if ok || status != NotInterrupted { t.FailNow() }
+}
+
+func TestChannelRecvInterruptibleBeforeMessage(t *testing.T) {
+	tx, rx := NewChannel[int]()
+	interrupt := make(chan struct{})
+	blocked := make(chan struct{})
+	rx.OnBlock(func() { close(blocked) })
+	go func() {
+		<-blocked
+		interrupt <- struct{}{}
+		tx.Send(1)
+	}()
+	_, status, ok := rx.RecvInterruptible(interrupt); if ok || status != Interrupted { t.FailNow() }
+	rx.OnBlock(nil)
+	msg, ok := rx.Recv(); if !ok || msg != 1 { t.FailNow() }
+}
+
+func TestChannelWithInitialCap(t *testing.T) {
+	tx, rx := NewChannelWithInitialCap[int](4)
+	for i := 0; i < 8; i++ { tx.Send(i) }
+	tx.Close()
+	for i := 0; i < 8; i++ {
+		msg, ok := rx.Recv(); if !ok || msg != i { t.FailNow() }
+	}
+	_, ok := rx.Recv(); if ok { t.FailNow() }
+
+	defer func() {
+		if recover() == nil { t.FailNow() }
+	}()
+	NewChannelWithInitialCap[int](-1)
+}
+
+func TestChannelSendReturningBacklog(t *testing.T) {
+	tx, rx := NewChannel[int]()
+	for i := 1; i <= 3; i++ {
+		if tx.SendReturningBacklog(i) != i { t.FailNow() }
+	}
+	rx.Recv()
+	if tx.SendReturningBacklog(4) != 3 { t.FailNow() }
+}
+
+func TestChannelLatencyPercentiles(t *testing.T) {
+	tx, rx := NewLatencyTrackedChannel[int]()
+	p50, p95, p99 := rx.LatencyPercentiles(); if p50 != 0 || p95 != 0 || p99 != 0 { t.FailNow() }
+
+	// 90 messages received at once and 10 after waiting 20ms.
+	for i := 0; i < 90; i++ {
+		tx.Send(i)
+		rx.Recv()
+	}
+	for i := 0; i < 10; i++ { tx.Send(i) }
+	time.Sleep(20 * time.Millisecond)
+	for i := 0; i < 10; i++ { rx.Recv() }
+
+	p50, p95, p99 = rx.LatencyPercentiles()
+	if p50 >= 10*time.Millisecond { t.FailNow() }
+	if p95 < 20*time.Millisecond || p99 < 20*time.Millisecond { t.FailNow() }
+}
+
+func TestChannelCloseAndWaitReceivers(t *testing.T) {
+	tx, rx1 := NewChannel[int]()
+	rx2 := rx1.Clone()
+	rx1.CloneWeak()
+	tx.Send(1)
+
+	done := make(chan struct{})
+	go func() {
+		tx.CloseAndWaitReceivers()
+		close(done)
+	}()
+	notDone := func() {
+		select {
+		case <-done:
+			t.FailNow()
+		case <-time.After(20 * time.Millisecond):
+		}
+	}
+	notDone()
+	msg, ok := rx1.Recv(); if !ok || msg != 1 { t.FailNow() }
+	_, ok = rx1.Recv(); if ok { t.FailNow() }
+	notDone()
+	_, ok = rx2.Recv(); if ok { t.FailNow() }
+	<-done
+}
+
+func TestBroadcastCloseAndWaitReceivers(t *testing.T) {
+	tx, rx := NewBroadcastWithReplay[int](1)
+	tx.Send(1)
+	done := make(chan struct{})
+	go func() {
+		tx.CloseAndWaitReceivers()
+		close(done)
+	}()
+	for _, ok := rx.Recv(); ok; _, ok = rx.Recv() {}
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.FailNow()
+	}
+}
+
+func TestChannelDrainClose(t *testing.T) {
+	tx, rx := NewChannel[int]()
+	other := rx.Clone()
+	tx.Send(1)
+	tx.Send(2)
+	msgs, err := rx.DrainClose(); if err != nil || !reflect.DeepEqual(msgs, []int{1, 2}) { t.FailNow() }
+	<-tx.abandoned()
+	tx.Send(3)
+	_, ok := other.Recv(); if ok { t.FailNow() }
+	_, err = rx.DrainClose(); if err != ErrClosed { t.FailNow() }
+}
+
+func TestChannelCount(t *testing.T) {
+	tx, rx := NewChannel[int]()
+	for i := 0; i < 7; i++ { tx.Send(i) }
+	tx.Close()
+	if rx.Count() != 7 { t.FailNow() }
+	if rx.Count() != 0 { t.FailNow() }
+}
+
+func TestChannelAgingPriority(t *testing.T) {
+	type job struct{ prio, id int }
+	tx, rx := NewAgingPriorityChannel(func(a, b job) bool { return a.prio > b.prio }, 50)
+	tx.Send(job{0, 0})
+	tx.Send(job{1, 1})
+	tx.Send(job{1, 2})
+	msg, ok := rx.Recv(); if !ok || msg.id != 1 { t.FailNow() }
+
+	// The low priority job overtakes the stream of high priority ones once it
+	// has waited 20ms longer than them.
+	for i := 3; ; i++ {
+		if i > 1000 { t.FailNow() }
+		time.Sleep(time.Millisecond)
+		tx.Send(job{1, i})
+		msg, ok = rx.Recv(); if !ok { t.FailNow() }
+		if msg.id == 0 { break }
+	}
+}
+
+func TestChannelCancelWhere(t *testing.T) {
+	type job struct{ id int }
+	tx, rx := NewChannel[job]()
+	for i := 1; i <= 4; i++ { tx.Send(job{i}) }
+	if tx.CancelWhere(func(j job) bool { return j.id == 3 }) != 1 { t.FailNow() }
+	if tx.CancelWhere(func(j job) bool { return j.id == 3 }) != 0 { t.FailNow() }
+	tx.Close()
+	for _, want := range []int{1, 2, 4} {
+		msg, ok := rx.Recv(); if !ok || msg.id != want { t.FailNow() }
+	}
+	_, ok := rx.Recv(); if ok { t.FailNow() }
+}
+
+func TestChannelSend2(t *testing.T) {
+	tx, rx := NewBoundedChannel[int](1)
+	if tx.Send2(1, false) != nil { t.FailNow() }
+	if tx.Send2(2, false) != ErrFull { t.FailNow() }
+
+	sent := make(chan error)
+	go func() { sent <- tx.Send2(2, true) }()
+	time.Sleep(10 * time.Millisecond)
+	msg, ok := rx.Recv(); if !ok || msg != 1 { t.FailNow() }
+	if <-sent != nil { t.FailNow() }
+	msg, ok = rx.Recv(); if !ok || msg != 2 { t.FailNow() }
+
+	tx.Close()
+	if tx.Send2(3, true) != ErrClosed { t.FailNow() }
+	if tx.Send2(3, false) != ErrClosed { t.FailNow() }
+}
+
+func TestChannelSend2TierFull(t *testing.T) {
+	tx, rx := NewTieredChannel(func(int) int { return 0 }, map[int]int{0: 1})
+	if err := tx.Send2(1, false); err != nil { t.FailNow() }
+	if err := tx.Send2(2, false); err != ErrFull { t.FailNow() }
+	if err := tx.Send2(3, true); err != ErrFull { t.FailNow() }
+	if rx.Len() != 1 { t.FailNow() }
+}
+
+func TestChannelAnyAll(t *testing.T) {
+	isEven := func(msg int) bool { return msg%2 == 0 }
+	tx, rx := NewChannel[int]()
+	for _, msg := range []int{1, 3, 4, 5} { tx.Send(msg) }
+	tx.Close()
+	if !rx.Any(isEven) { t.FailNow() }
+	msg, ok := rx.Recv(); if !ok || msg != 5 { t.FailNow() }
+	if rx.Any(isEven) { t.FailNow() }
+
+	tx, rx = NewChannel[int]()
+	for _, msg := range []int{2, 4, 5, 6} { tx.Send(msg) }
+	tx.Close()
+	if rx.All(isEven) { t.FailNow() }
+	msg, ok = rx.Recv(); if !ok || msg != 6 { t.FailNow() }
+	if !rx.All(isEven) { t.FailNow() }
+}
+
+type lifo []int
+
+func (me *lifo) Push(msg int) { *me = append(*me, msg) }
+func (me *lifo) Pop() int { msg := (*me)[len(*me)-1]; *me = (*me)[:len(*me)-1]; return msg }
+func (me *lifo) Peek() int { return (*me)[len(*me)-1] }
+func (me *lifo) Len() int { return len(*me) }
+func (me *lifo) Remove(remove func(int) bool) int { n := len(*me); *me = slices.DeleteFunc(*me, remove); return n - len(*me) }
+
+func TestNewChannelWithQueue(t *testing.T) {
+	tx, rx := NewChannelWithQueue[int](&lifo{})
+	for i := 1; i <= 3; i++ { tx.Send(i) }
+	if rx.Len() != 3 { t.FailNow() }
+	tx.Close()
+	for want := 3; want >= 1; want-- {
+		msg, ok := rx.Recv(); if !ok || msg != want { t.FailNow() }
+	}
+	if _, ok := rx.Recv(); ok { t.FailNow() }
+}
+
+func TestNewStackChannel(t *testing.T) {
+	tx, rx := NewStackChannel[int]()
+	tx.Send(1); tx.Send(2); tx.Send(3)
+	tx.Close()
+	for want := 3; want >= 1; want-- {
+		msg, ok := rx.Recv(); if !ok || msg != want { t.FailNow() }
+	}
+	if _, ok := rx.Recv(); ok { t.FailNow() }
+}
+
+func TestNewStackChannelRemove(t *testing.T) {
+	tx, rx := NewStackChannel[int]()
+	for i := 1; i <= 4; i++ { tx.Send(i) }
+	if tx.CancelWhere(func(x int) bool { return x == 2 }) != 1 { t.FailNow() }
+	msgs, ok := rx.RecvBurst(); if !ok || !reflect.DeepEqual(msgs, []int{4, 3, 1}) { t.FailNow() }
+
+	for i := 1; i <= 4; i++ { tx.Send(i) }
+	if rx.Compact(func(x int) bool { return x == 3 }) != 1 { t.FailNow() }
+	msgs, ok = rx.RecvBurst(); if !ok || !reflect.DeepEqual(msgs, []int{4, 2, 1}) { t.FailNow() }
+}
+
+func TestChannelCloseWakesBlockedReceivers(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for round := 0; round < 200; round++ {
+		tx, rx := NewChannel[int]()
+		n := 1 + rng.Intn(16)
+		var wg sync.WaitGroup
+		for i := 0; i < n; i++ {
+			rx := rx.Clone()
+			wg.Add(1)
+			go func(kind int) {
+				defer wg.Done()
+				switch kind {
+				case 0: rx.Recv()
+				case 1: rx.RecvTimed()
+				case 2: rx.RecvBurst()
+				case 3: rx.RecvPtr()
+				}
+			}(rng.Intn(4))
+		}
+		// Close at a random point while the receivers are still blocking.
+		for i := rng.Intn(2 * n); i > 0; i-- { runtime.Gosched() }
+		tx.Close()
+		done := make(chan struct{})
+		go func() { wg.Wait(); close(done) }()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second): t.Fatalf("round %d: receivers still blocked after close", round)
+		}
+		rx.Close()
+	}
+}
+
+func TestChannelReadyChan(t *testing.T) {
+	tx, rx := NewChannel[int]()
+	ready := rx.ReadyChan()
+	select {
+	case <-ready: t.FailNow()
+	default:
+	}
+	if _, status := rx.TryRecv(); status != Idle { t.FailNow() }
+	go func() { tx.Send(1); tx.Send(2); tx.Close() }()
+
+	got := []int{}
+	for closed := false; !closed; {
+		select {
+		case <-ready:
+		case <-time.After(5 * time.Second): t.FailNow()
+		}
+		for {
+			msg, status := rx.TryRecv()
+			if status == Idle { break }
+			if status == Closed { closed = true; break }
+			got = append(got, msg)
+		}
+	}
+	if !reflect.DeepEqual(got, []int{1, 2}) { t.FailNow() }
+}
+
+func TestChannelReadyChanResume(t *testing.T) {
+	tx, rx := NewChannel[int]()
+	rx.Pause()
+	tx.Send(1)
+	ready := rx.ReadyChan()
+	select {
+	case <-ready: t.FailNow()
+	default:
+	}
+	rx.Resume()
+	select {
+	case <-ready:
+	case <-time.After(5 * time.Second): t.FailNow()
+	}
+	msg, status := rx.TryRecv(); if status != Delivered || msg != 1 { t.FailNow() }
+}
+
+func TestChannelChan(t *testing.T) {
+	tx, rx := NewChannel[int]()
+	go func() {
+		for i := 0; i < 5; i++ { tx.Send(i) }
+		tx.Close()
+	}()
+	got := []int{}
+	for msg := range rx.Chan() { got = append(got, msg) }
+	if !reflect.DeepEqual(got, []int{0, 1, 2, 3, 4}) { t.FailNow() }
+}
+
+func TestChannelStopChan(t *testing.T) {
+	tx, rx := NewChannel[int]()
+	for i := 0; i < 5; i++ { tx.Send(i) }
+	for msg := range rx.Chan() {
+		if msg == 1 { break }
+	}
+	rx.StopChan()
+	n := 2
+	select {
+	case _, ok := <-rx.Chan():
+		for ; ok; _, ok = <-rx.Chan() { n++ }
+	case <-time.After(5 * time.Second): t.FailNow()
+	}
+	// The message in the pump's hands when it stopped was put back.
+	if n += rx.Len(); n != 5 { t.FailNow() }
+}
+
+func TestNewChannelWithMaxClones(t *testing.T) {
+	tx, rx := NewChannelWithMaxClones[int](3, 2)
+	tx2 := tx.Clone(); tx.Clone()
+	rx.Clone()
+	panics := func(f func()) (did bool) {
+		defer func() { did = recover() != nil }()
+		f()
+		return false
+	}
+	if !panics(func() { tx.Clone() }) { t.FailNow() }
+	if !panics(func() { rx.Clone() }) { t.FailNow() }
+	tx2.Close()
+	if panics(func() { tx.Clone() }) { t.FailNow() }
+}
+
+func TestChannelRecvTx(t *testing.T) {
+	tx, rx := NewChannel[int]()
+	tx.Send(1); tx.Send(2)
+	msg, _, rollback, ok := rx.RecvTx(); if !ok || msg != 1 { t.FailNow() }
+	rollback()
+	rollback()
+	if rx.Len() != 2 { t.FailNow() }
+	msg, commit, rollback, ok := rx.RecvTx(); if !ok || msg != 1 { t.FailNow() }
+	commit()
+	rollback()
+	tx.Close()
+	msg, ok = rx.Recv(); if !ok || msg != 2 { t.FailNow() }
+	_, _, _, ok = rx.RecvTx(); if ok { t.FailNow() }
+}
+
+func TestChannelRecvTxRollbackAfterClose(t *testing.T) {
+	tx, rx := NewChannel[int]()
+	rx2 := rx.Clone()
+	tx.Send(1)
+	tx.Close()
+	_, _, rollback, ok := rx.RecvTx(); if !ok { t.FailNow() }
+	_, ok = rx2.Recv(); if ok { t.FailNow() }
+	rollback()
+	_, ok = rx2.Recv(); if ok { t.FailNow() }
+	if rx.Len() != 0 { t.FailNow() }
+}
+
+func TestChannelRecvMerged(t *testing.T) {
+	type kv struct { key string; n int }
+	tx, rx := NewChannel[kv]()
+	for _, msg := range []kv{{"a", 1}, {"a", 2}, {"a", 3}, {"b", 4}, {"a", 5}} { tx.Send(msg) }
+	tx.Close()
+	sameKey := func(a, b kv) bool { return a.key == b.key }
+	sum := func(a, b kv) kv { return kv{a.key, a.n + b.n} }
+	msg, ok := rx.RecvMerged(sameKey, sum); if !ok || msg != (kv{"a", 6}) { t.FailNow() }
+	msg, ok = rx.RecvMerged(sameKey, sum); if !ok || msg != (kv{"b", 4}) { t.FailNow() }
+	msg, ok = rx.RecvMerged(sameKey, sum); if !ok || msg != (kv{"a", 5}) { t.FailNow() }
+	_, ok = rx.RecvMerged(sameKey, sum); if ok { t.FailNow() }
+}
+
+func TestStackChannelRejectsMetaSends(t *testing.T) {
+	tx, rx := NewStackChannel[int]()
+	func() {
+		defer func() {
+			if recover() == nil { t.FailNow() }
+		}()
+		tx.SendTrackedBounded(1, 1)
+	}()
+	func() {
+		defer func() {
+			if recover() == nil { t.FailNow() }
+		}()
+		tx.SendWithDeadline(1, time.Now().Add(-time.Second))
+	}()
+	tx.Send(2)
+	if rx.Len() != 1 { t.FailNow() }
+}
+
+func BenchmarkRecvBurst(b *testing.B) {
+	tx, rx := NewChannel[int]()
+	for i := 0; i < b.N; i++ {
+		for j := 0; j < 64; j++ {
+			tx.Send(j)
+		}
+		for n := 0; n < 64; {
+			msgs, _ := rx.RecvBurst()
+			n += len(msgs)
+		}
+	}
+}
+
+func BenchmarkRecvInto(b *testing.B) {
+	tx, rx := NewChannel[int]()
+	buf := make([]int, 64)
+	for i := 0; i < b.N; i++ {
+		for j := 0; j < 64; j++ {
+			tx.Send(j)
+		}
+		for n := 0; n < 64; {
+			k, _ := rx.RecvInto(buf)
+			n += k
+		}
+	}
+}
+
+func BenchmarkBurstDefault(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		tx, _ := NewChannel[int]()
+		for j := 0; j < 1024; j++ {
+			tx.Send(j)
+		}
+	}
+}
+
+func BenchmarkBurstInitialCap(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		tx, _ := NewChannelWithInitialCap[int](1024)
+		for j := 0; j < 1024; j++ {
+			tx.Send(j)
+		}
+	}
+}
@@ -0,0 +1,27 @@
+// Package manchantest provides helpers for testing code built on manchan
+// channels.
+package manchantest
+
+import (
+	"testing"
+
+	manchan "github.com/rsanden-deca/manchan/manchango"
+)
+
+// ExpectSequence receives len(want) messages from rx, failing t unless they
+// equal want in order, and then fails t unless rx reports closed.
+func ExpectSequence[T comparable](t testing.TB, rx *manchan.Receiver[T], want []T) {
+	t.Helper()
+	for i, w := range want {
+		msg, ok := rx.Recv()
+		if !ok {
+			t.Fatalf("message %d: channel closed, want %v", i, w)
+		}
+		if msg != w {
+			t.Fatalf("message %d: got %v, want %v", i, msg, w)
+		}
+	}
+	if msg, ok := rx.Recv(); ok {
+		t.Fatalf("got extra message %v, want channel closed", msg)
+	}
+}
@@ -0,0 +1,18 @@
+package manchantest
+
+import (
+	"testing"
+
+	manchan "github.com/rsanden-deca/manchan/manchango"
+)
+
+func TestExpectSequence(t *testing.T) {
+	tx, rx := manchan.NewChannel[string]()
+	go func() {
+		for _, msg := range []string{"a", "b", "c"} {
+			tx.Send(msg)
+		}
+		tx.Close()
+	}()
+	ExpectSequence(t, rx, []string{"a", "b", "c"})
+}
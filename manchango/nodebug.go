@@ -0,0 +1,6 @@
+//go:build !manchan_debug
+
+package manchan
+
+// check_woken is a no-op outside manchan_debug builds; see debug.go.
+func (me *Shared[T]) check_woken() {}
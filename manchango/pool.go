@@ -0,0 +1,106 @@
+package manchan
+
+import "sync"
+
+// WorkerPool starts workers goroutines, each receiving from its own clone of
+// rx and calling handle on every message. The returned function blocks until
+// rx has closed and every worker has finished.
+func WorkerPool[T any](rx *Receiver[T], workers int, handle func(T)) func() {
+	return worker_pool(rx, workers, nil, handle)
+}
+
+// worker_pool is WorkerPool, with workers also stopping once cancel closes.
+func worker_pool[T any](rx *Receiver[T], workers int, cancel <-chan struct{}, handle func(T)) func() {
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		worker_rx := rx.Clone()
+		wg.Add(1)
+		go_stage(func() {
+			defer wg.Done()
+			defer worker_rx.Close()
+			for {
+				msg, ok, cancelled := worker_rx.RecvCancel(cancel)
+				if !ok || cancelled {
+					return
+				}
+				handle(msg)
+			}
+		})
+	}
+	return wg.Wait
+}
+
+// WorkerPoolMap runs f on every message from rx across workers goroutines and
+// sends the results on the returned receiver, which closes once every input
+// has been processed. Results arrive in completion order, not input order;
+// see WorkerPoolMapOrdered. The output buffers StageBuffer results, and the
+// workers stop early once every receiver of the output has closed. rx is
+// closed once the workers have finished.
+func WorkerPoolMap[T, U any](rx *Receiver[T], workers int, f func(T) U) *Receiver[U] {
+	tx_out, rx_out := NewBoundedChannel[U](StageBuffer)
+	wait := worker_pool(rx, workers, tx_out.abandoned(), func(msg T) {
+		tx_out.Send(f(msg))
+	})
+	go_stage(func() {
+		wait()
+		rx.Close()
+		tx_out.Close()
+	})
+	return rx_out
+}
+
+type sequenced[T any] struct {
+	seq   uint64
+	value T
+}
+
+// WorkerPoolMapOrdered is WorkerPoolMap, except that results are sent in the
+// order their inputs were received. Results that finish early are held back
+// until every earlier one has been sent, so one slow message delays all the
+// results behind it. At most workers plus StageBuffer inputs are in progress
+// at a time, which bounds how many results are held back.
+func WorkerPoolMapOrdered[T, U any](rx *Receiver[T], workers int, f func(T) U) *Receiver[U] {
+	// window holds a token for every input handed out and not yet sent on
+	// as a result, so that results held back stay bounded.
+	tx_window, rx_window := NewBoundedChannel[struct{}](workers + StageBuffer)
+	tx_in, rx_in := NewChannel[sequenced[T]]()
+	go_stage(func() {
+		defer tx_in.Close()
+		defer rx.Close()
+		for seq := uint64(0); ; seq++ {
+			msg, ok, abandoned := rx.RecvCancel(tx_in.abandoned())
+			if !ok || abandoned {
+				return
+			}
+			tx_window.Send(struct{}{})
+			tx_in.Send(sequenced[T]{seq: seq, value: msg})
+		}
+	})
+
+	rx_done := WorkerPoolMap(rx_in, workers, func(msg sequenced[T]) sequenced[U] {
+		return sequenced[U]{seq: msg.seq, value: f(msg.value)}
+	})
+
+	tx_out, rx_out := NewBoundedChannel[U](StageBuffer)
+	go_stage(func() {
+		defer tx_out.Close()
+		defer rx_done.Close()
+		defer rx_window.Close()
+		pending := map[uint64]U{}
+		next := uint64(0)
+		for {
+			msg, ok, abandoned := rx_done.RecvCancel(tx_out.abandoned())
+			if !ok || abandoned {
+				return
+			}
+			pending[msg.seq] = msg.value
+			for value, ready := pending[next]; ready; value, ready = pending[next] {
+				delete(pending, next)
+				tx_out.Send(value)
+				rx_window.Recv()
+				next++
+			}
+		}
+	})
+	return rx_out
+}
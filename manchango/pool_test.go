@@ -0,0 +1,88 @@
+package manchan
+
+import (
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWorkerPool(t *testing.T) {
+	tx, rx := NewChannel[int]()
+	var mu sync.Mutex
+	handled := map[int]int{}
+	wait := WorkerPool(rx, 4, func(i int) {
+		mu.Lock()
+		handled[i] += 1
+		mu.Unlock()
+	})
+
+	for i := 0; i < 100; i++ {
+		tx.Send(i)
+	}
+	tx.Close()
+	wait()
+
+	if len(handled) != 100 { t.FailNow() }
+	for _, count := range handled {
+		if count != 1 { t.FailNow() }
+	}
+}
+
+func TestWorkerPoolMap(t *testing.T) {
+	tx, rx := NewChannel[int]()
+	for i := 0; i < 50; i++ {
+		tx.Send(i)
+	}
+	tx.Close()
+
+	results := []int{}
+	out := WorkerPoolMap(rx, 5, func(i int) int { return i * i })
+	for msg, ok := out.Recv(); ok; msg, ok = out.Recv() {
+		results = append(results, msg)
+	}
+	sort.Ints(results)
+
+	if len(results) != 50 { t.FailNow() }
+	for i, msg := range results {
+		if msg != i*i { t.FailNow() }
+	}
+}
+
+func TestWorkerPoolMapOrdered(t *testing.T) {
+	tx, rx := NewChannel[int]()
+	for i := 0; i < 20; i++ {
+		tx.Send(i)
+	}
+	tx.Close()
+
+	results := []int{}
+	out := WorkerPoolMapOrdered(rx, 4, func(i int) int {
+		time.Sleep(time.Duration((i*7)%5) * time.Millisecond)
+		return -i
+	})
+	for msg, ok := out.Recv(); ok; msg, ok = out.Recv() {
+		results = append(results, msg)
+	}
+
+	if len(results) != 20 { t.FailNow() }
+	for i, msg := range results {
+		if msg != -i { t.FailNow() }
+	}
+}
+
+func TestWorkerPoolMapAbandoned(t *testing.T) {
+	check := LeakCheck()
+	tx, rx := NewChannel[int]()
+	out := WorkerPoolMap(rx, 3, func(i int) int { return i })
+	outOrdered := WorkerPoolMapOrdered(rx.Clone(), 3, func(i int) int { return i })
+	for i := 0; i < 100; i++ {
+		tx.Send(i)
+	}
+	out.Recv()
+	outOrdered.Recv()
+	out.Close()
+	outOrdered.Close()
+	if err := check(); err != nil { t.Fatal(err) }
+	tx.Close()
+}
@@ -0,0 +1,66 @@
+package manchan
+
+type request[Req, Resp any] struct {
+	req   Req
+	reply *Sender[Resp]
+}
+
+// Client sends requests to a Server and waits for the replies; see
+// NewRequestChannel.
+type Client[Req, Resp any] struct {
+	tx *Sender[request[Req, Resp]]
+}
+
+// Server answers the requests of one or more Clients.
+type Server[Req, Resp any] struct {
+	rx *Receiver[request[Req, Resp]]
+}
+
+// NewRequestChannel returns the two ends of a request/reply channel. Each
+// Call carries a private reply channel along with its request, so that
+// concurrent Calls, from one Client or from clones of it, each get the
+// response to their own request.
+func NewRequestChannel[Req, Resp any]() (*Client[Req, Resp], *Server[Req, Resp]) {
+	tx, rx := NewChannel[request[Req, Resp]]()
+	return &Client[Req, Resp]{tx: tx}, &Server[Req, Resp]{rx: rx}
+}
+
+// Call sends req to the server and waits for its response. If the server
+// has closed, or closes before answering, it returns the zero Resp.
+func (me *Client[Req, Resp]) Call(req Req) Resp {
+	tx_reply, rx_reply := NewChannel[Resp]()
+	me.tx.Send(request[Req, Resp]{req: req, reply: tx_reply})
+	resp, _, _ := rx_reply.RecvCancel(me.tx.abandoned())
+	return resp
+}
+
+// Clone returns another client of the same server.
+func (me *Client[Req, Resp]) Clone() *Client[Req, Resp] {
+	return &Client[Req, Resp]{tx: me.tx.Clone()}
+}
+
+// Close closes this client. Serve returns once every client has closed.
+func (me *Client[Req, Resp]) Close() {
+	me.tx.Close()
+}
+
+// Serve answers requests with handle, one at a time, until every client has
+// closed. Run it from several goroutines, on clones of the server, to answer
+// requests concurrently.
+func (me *Server[Req, Resp]) Serve(handle func(Req) Resp) {
+	for r, ok := me.rx.Recv(); ok; r, ok = me.rx.Recv() {
+		r.reply.Send(handle(r.req))
+		r.reply.Close()
+	}
+}
+
+// Clone returns another server taking requests from the same clients.
+func (me *Server[Req, Resp]) Clone() *Server[Req, Resp] {
+	return &Server[Req, Resp]{rx: me.rx.Clone()}
+}
+
+// Close closes this server. Once every server has closed, pending and future
+// Calls return the zero Resp.
+func (me *Server[Req, Resp]) Close() {
+	me.rx.Close()
+}
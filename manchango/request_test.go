@@ -0,0 +1,35 @@
+package manchan
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestRequestChannel(t *testing.T) {
+	client, server := NewRequestChannel[int, int]()
+	done := make(chan struct{})
+	go func() {
+		server.Serve(func(req int) int { return req * 10 })
+		close(done)
+	}()
+
+	var wg sync.WaitGroup
+	for i := 1; i <= 3; i++ {
+		client := client.Clone()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer client.Close()
+			if resp := client.Call(i); resp != i*10 { t.Error(resp) }
+		}()
+	}
+	wg.Wait()
+	client.Close()
+	<-done
+}
+
+func TestRequestChannelServerClosed(t *testing.T) {
+	client, server := NewRequestChannel[int, int]()
+	server.Close()
+	if resp := client.Call(1); resp != 0 { t.FailNow() }
+}
@@ -0,0 +1,36 @@
+package manchan
+
+type result[T any] struct {
+	value T
+	err   error
+}
+
+// ResultChannel carries values and errors through one ordered stream.
+type ResultChannel[T any] struct {
+	tx *Sender[result[T]]
+	rx *Receiver[result[T]]
+}
+
+func NewResultChannel[T any]() *ResultChannel[T] {
+	tx, rx := NewChannel[result[T]]()
+	return &ResultChannel[T]{tx: tx, rx: rx}
+}
+
+func (me *ResultChannel[T]) Send(value T) {
+	me.tx.Send(result[T]{value: value})
+}
+
+func (me *ResultChannel[T]) SendErr(err error) {
+	me.tx.Send(result[T]{err: err})
+}
+
+func (me *ResultChannel[T]) Close() {
+	me.tx.Close()
+}
+
+// Recv returns the next value or error in send order. ok is false once the
+// channel is closed and drained.
+func (me *ResultChannel[T]) Recv() (value T, err error, ok bool) {
+	r, ok := me.rx.Recv()
+	return r.value, r.err, ok
+}
@@ -0,0 +1,20 @@
+package manchan
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestResultChannel(t *testing.T) {
+	boom := errors.New("boom")
+	ch := NewResultChannel[int]()
+	ch.Send(1)
+	ch.SendErr(boom)
+	ch.Send(2)
+	ch.Close()
+
+	value, err, ok := ch.Recv(); if !ok || err != nil || value != 1 { t.FailNow() }
+	value, err, ok = ch.Recv(); if !ok || err != boom || value != 0 { t.FailNow() }
+	value, err, ok = ch.Recv(); if !ok || err != nil || value != 2 { t.FailNow() }
+	_, _, ok = ch.Recv(); if ok { t.FailNow() }
+}
@@ -0,0 +1,144 @@
+package manchan
+
+import "context"
+
+// Selector waits on several channel operations at once and performs whichever
+// can proceed first, like a select statement over manchan channels. Add cases
+// with AddRecv and AddSend, then call Run.
+type Selector struct {
+	cases []select_case
+}
+
+type select_case interface {
+	// try performs the operation if it can proceed without waiting. dead
+	// reports that it never will.
+	try() (done bool, dead bool)
+	watch(wake chan struct{})
+	unwatch(wake chan struct{})
+	// fire calls the case's handler once try has succeeded.
+	fire()
+}
+
+func NewSelector() *Selector {
+	return &Selector{}
+}
+
+// AddRecv adds a case receiving from rx, calling handler with the message.
+func AddRecv[T any](sel *Selector, rx *Receiver[T], handler func(T)) {
+	sel.cases = append(sel.cases, &recv_case[T]{rx: rx, handler: handler})
+}
+
+// AddSend adds a case sending msg to tx, calling handler once it is sent.
+// Only a bounded channel can leave a send waiting.
+func AddSend[T any](sel *Selector, tx *Sender[T], msg T, handler func()) {
+	sel.cases = append(sel.cases, &send_case[T]{tx: tx, msg: msg, handler: handler})
+}
+
+// Run waits until one of the cases can proceed, performs it and calls its
+// handler. If several are ready at once the one added first wins. It returns
+// ctx.Err() if ctx is done first, or ErrClosed once no case can ever proceed.
+// The cases are kept, so Run can be called again in a loop.
+func (me *Selector) Run(ctx context.Context) error {
+	wake := make(chan struct{}, 1)
+	for _, c := range me.cases {
+		c.watch(wake)
+	}
+	defer func() {
+		for _, c := range me.cases {
+			c.unwatch(wake)
+		}
+	}()
+	for {
+		n_dead := 0
+		for _, c := range me.cases {
+			done, dead := c.try()
+			if done {
+				c.fire()
+				return nil
+			}
+			if dead {
+				n_dead += 1
+			}
+		}
+		if n_dead == len(me.cases) {
+			return ErrClosed
+		}
+		select {
+		case <-wake:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+type recv_case[T any] struct {
+	rx      *Receiver[T]
+	handler func(T)
+	msg     T
+}
+
+func (me *recv_case[T]) try() (bool, bool) {
+	msg, ok, closed := me.rx.try_recv()
+	me.msg = msg
+	return ok, closed
+}
+
+func (me *recv_case[T]) watch(wake chan struct{}) {
+	me.rx.shared.inner.Lock()
+	me.rx.shared.inner.watch(wake)
+	me.rx.shared.inner.Unlock()
+}
+
+func (me *recv_case[T]) unwatch(wake chan struct{}) {
+	me.rx.shared.inner.Lock()
+	me.rx.shared.inner.unwatch(wake)
+	me.rx.shared.inner.Unlock()
+}
+
+func (me *recv_case[T]) fire() {
+	msg := me.msg
+	me.msg = *new(T)
+	me.handler(msg)
+}
+
+type send_case[T any] struct {
+	tx      *Sender[T]
+	msg     T
+	handler func()
+}
+
+func (me *send_case[T]) try() (bool, bool) {
+	err := me.tx.send(me.msg, false)
+	if err == err_sender_closed {
+		panic(err.Error())
+	}
+	return err == nil, err != nil && err != ErrFull
+}
+
+func (me *send_case[T]) watch(wake chan struct{}) {
+	me.tx.shared.inner.Lock()
+	me.tx.shared.inner.watch(wake)
+	me.tx.shared.inner.Unlock()
+}
+
+func (me *send_case[T]) unwatch(wake chan struct{}) {
+	me.tx.shared.inner.Lock()
+	me.tx.shared.inner.unwatch(wake)
+	me.tx.shared.inner.Unlock()
+}
+
+func (me *send_case[T]) fire() {
+	me.handler()
+}
+
+// SelectRecvSend waits until it can either receive from rx or send msg to tx,
+// and does whichever is possible first, like a select with a receive case and
+// a send case. If both are ready it receives. It returns with neither done
+// once rx has closed and tx can no longer accept msg.
+func SelectRecvSend[T any](rx *Receiver[T], tx *Sender[T], msg T) (recvMsg T, didRecv bool, didSend bool) {
+	sel := NewSelector()
+	AddRecv(sel, rx, func(msg T) { recvMsg, didRecv = msg, true })
+	AddSend(sel, tx, msg, func() { didSend = true })
+	sel.Run(context.Background())
+	return recvMsg, didRecv, didSend
+}
@@ -0,0 +1,58 @@
+package manchan
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestSelectRecvSend(t *testing.T) {
+	inTx, inRx := NewChannel[int]()
+	outTx, outRx := NewBoundedChannel[int](1)
+	_, didRecv, didSend := SelectRecvSend(inRx, outTx, 7)
+	if didRecv || !didSend { t.FailNow() }
+	msg, ok := outRx.Recv(); if !ok || msg != 7 { t.FailNow() }
+
+	outTx.Send(8)
+	go func() { time.Sleep(10 * time.Millisecond); inTx.Send(1) }()
+	msg, didRecv, didSend = SelectRecvSend(inRx, outTx, 9)
+	if !didRecv || didSend || msg != 1 { t.FailNow() }
+}
+
+func TestSelector(t *testing.T) {
+	inTx, inRx := NewChannel[int]()
+	outTx, outRx := NewBoundedChannel[int](1)
+	outTx.Send(0)
+
+	var got []int
+	sel := NewSelector()
+	AddRecv(sel, inRx, func(msg int) { got = append(got, msg) })
+	AddSend(sel, outTx, 5, func() { got = append(got, -1) })
+
+	inTx.Send(1)
+	if sel.Run(context.Background()) != nil || len(got) != 1 || got[0] != 1 { t.FailNow() }
+
+	outRx.Recv()
+	if sel.Run(context.Background()) != nil || len(got) != 2 || got[1] != -1 { t.FailNow() }
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if sel.Run(ctx) != context.DeadlineExceeded { t.FailNow() }
+
+	inTx.Close()
+	outRx.Close()
+	if sel.Run(context.Background()) != ErrClosed { t.FailNow() }
+}
+
+func TestSelectorResume(t *testing.T) {
+	tx, rx := NewChannel[int]()
+	tx.Send(1)
+	rx.Pause()
+	got := 0
+	sel := NewSelector()
+	AddRecv(sel, rx, func(msg int) { got = msg })
+	go func() { time.Sleep(10 * time.Millisecond); rx.Resume() }()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if sel.Run(ctx) != nil || got != 1 { t.FailNow() }
+}
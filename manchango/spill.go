@@ -0,0 +1,204 @@
+package manchan
+
+import (
+	"encoding/binary"
+	"os"
+	"runtime"
+)
+
+// spill_segment_size is how large a spill file grows before later messages
+// go to a new one, so that files can be removed as they are read back
+// instead of growing for as long as the channel stays backed up.
+var spill_segment_size int64 = 1 << 20
+
+type spill_segment struct {
+	file      *os.File
+	read_off  int64
+	write_off int64
+	n         int
+}
+
+// spill_files holds a spill's open files apart from the spill itself, which
+// the channel refers back to, so that a finalizer can remove them once the
+// channel is garbage.
+type spill_files struct {
+	segments []*spill_segment
+}
+
+func (me *spill_files) remove_first() {
+	seg := me.segments[0]
+	seg.file.Close()
+	os.Remove(seg.file.Name())
+	me.segments[0] = nil
+	me.segments = me.segments[1:]
+}
+
+func (me *spill_files) remove_all() {
+	for len(me.segments) > 0 {
+		me.remove_first()
+	}
+}
+
+// spill is a FIFO Queue that keeps up to capacity messages in memory and
+// appends the rest to files, reading them back in order as the in-memory
+// part drains. Once anything has spilled, new messages go to the files until
+// they are empty again, which keeps them in order. A file is removed once
+// everything in it has been read back.
+//
+// An I/O error cuts the channel short through fail. A message that could not
+// be written is kept in memory in tail, after the spilled ones; messages that
+// could not be read back are lost.
+type spill[T any] struct {
+	mem       []T
+	tail      []T
+	capacity  int
+	encode    func(T) []byte
+	decode    func([]byte) T
+	dir       string
+	files     *spill_files
+	n_spilled int
+	is_failed bool
+	fail      func(err error, n_lost int)
+}
+
+func (me *spill[T]) Push(msg T) {
+	switch {
+	case me.n_spilled == 0 && len(me.tail) == 0 && len(me.mem) < me.capacity:
+		me.mem = append(me.mem, msg)
+	case me.is_failed || len(me.tail) > 0:
+		me.tail = append(me.tail, msg)
+	default:
+		if err := me.write(msg); err != nil {
+			me.tail = append(me.tail, msg)
+			me.is_failed = true
+			me.fail(err, 0)
+		}
+	}
+}
+
+func (me *spill[T]) write(msg T) error {
+	segments := me.files.segments
+	if len(segments) == 0 || segments[len(segments)-1].write_off >= spill_segment_size {
+		file, err := os.CreateTemp(me.dir, "manchan-spill-*")
+		if err != nil {
+			return err
+		}
+		me.files.segments = append(me.files.segments, &spill_segment{file: file})
+	}
+	seg := me.files.segments[len(me.files.segments)-1]
+	data := me.encode(msg)
+	record := binary.BigEndian.AppendUint32(make([]byte, 0, 4+len(data)), uint32(len(data)))
+	record = append(record, data...)
+	if _, err := seg.file.WriteAt(record, seg.write_off); err != nil {
+		return err
+	}
+	seg.write_off += int64(len(record))
+	seg.n += 1
+	me.n_spilled += 1
+	return nil
+}
+
+func (me *spill[T]) Pop() T {
+	msg := me.mem[0]
+	var zero T
+	me.mem[0] = zero
+	me.mem = me.mem[1:]
+	if me.n_spilled > 0 {
+		next, err := me.read()
+		if err == nil {
+			me.mem = append(me.mem, next)
+			return msg
+		}
+		n_lost := me.n_spilled
+		me.files.remove_all()
+		me.n_spilled = 0
+		me.is_failed = true
+		me.fail(err, n_lost)
+	}
+	if len(me.tail) > 0 {
+		me.mem = append(me.mem, me.tail[0])
+		me.tail[0] = zero
+		me.tail = me.tail[1:]
+	}
+	return msg
+}
+
+// read reads back the oldest spilled message, removing its file once it has
+// been emptied.
+func (me *spill[T]) read() (T, error) {
+	seg := me.files.segments[0]
+	var size [4]byte
+	if _, err := seg.file.ReadAt(size[:], seg.read_off); err != nil {
+		return *new(T), err
+	}
+	data := make([]byte, binary.BigEndian.Uint32(size[:]))
+	if _, err := seg.file.ReadAt(data, seg.read_off+4); err != nil {
+		return *new(T), err
+	}
+	seg.read_off += 4 + int64(len(data))
+	seg.n -= 1
+	me.n_spilled -= 1
+	if seg.n == 0 {
+		me.files.remove_first()
+	}
+	return me.decode(data), nil
+}
+
+func (me *spill[T]) Peek() T { return me.mem[0] }
+
+func (me *spill[T]) Len() int { return len(me.mem) + me.n_spilled + len(me.tail) }
+
+// Remove reads every spilled message back in order to filter it, then spills
+// again whatever no longer fits in memory.
+func (me *spill[T]) Remove(remove func(T) bool) int {
+	var kept []T
+	n := 0
+	for me.Len() > 0 {
+		msg := me.Pop()
+		if remove(msg) {
+			n++
+			continue
+		}
+		kept = append(kept, msg)
+	}
+	for _, msg := range kept {
+		me.Push(msg)
+	}
+	return n
+}
+
+// NewSpillingChannel returns a channel that buffers up to capacity messages
+// in memory and spills any beyond that to temporary files in dir, encoded
+// with encode, reading them back with decode in order as the memory buffer
+// drains. Sends never wait, so a slow consumer costs disk rather than
+// memory. Spilled messages live only as long as the channel: each file is
+// removed once read back, any left over are removed once the channel is
+// garbage collected, and none are read again after a restart.
+//
+// If writing or reading a file fails, the channel is cut short with the
+// error: later sends are dropped, receivers get what is still readable and
+// then see the channel closed, and Err returns the error. Spilled messages
+// that could not be read back are lost. capacity must be positive.
+func NewSpillingChannel[T any](capacity int, encode func(T) []byte, decode func([]byte) T, dir string) (*Sender[T], *Receiver[T]) {
+	if capacity < 1 {
+		panic("manchan: capacity must be positive")
+	}
+	files := &spill_files{}
+	runtime.SetFinalizer(files, (*spill_files).remove_all)
+	q := &spill[T]{capacity: capacity, encode: encode, decode: decode, dir: dir, files: files}
+	tx, rx := NewChannelWithQueue[T](q)
+	shared := tx.shared
+	// fail runs with the channel's lock held, from inside push or pop.
+	q.fail = func(err error, n_lost int) {
+		for i := 0; i < n_lost; i++ {
+			shared.inner.release(nil)
+		}
+		if shared.inner.err == nil {
+			shared.inner.err = err
+		}
+		shared.inner.is_cut_short = true
+		shared.inner.mark_done()
+		shared.wake_all()
+	}
+	return tx, rx
+}
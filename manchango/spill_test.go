@@ -0,0 +1,92 @@
+package manchan
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestSpillingChannel(t *testing.T) {
+	dir := t.TempDir()
+	encode := func(i int) []byte { return []byte(strconv.Itoa(i)) }
+	decode := func(data []byte) int { i, _ := strconv.Atoi(string(data)); return i }
+	tx, rx := NewSpillingChannel(4, encode, decode, dir)
+	for i := 0; i < 20; i++ {
+		tx.Send(i)
+	}
+	if files, _ := os.ReadDir(dir); len(files) != 1 { t.FailNow() }
+	if rx.Len() != 20 { t.FailNow() }
+
+	for i := 0; i < 10; i++ {
+		msg, ok := rx.Recv(); if !ok || msg != i { t.FailNow() }
+	}
+	tx.Send(20)
+	tx.Close()
+	for i := 10; i <= 20; i++ {
+		msg, ok := rx.Recv(); if !ok || msg != i { t.FailNow() }
+	}
+	_, ok := rx.Recv(); if ok { t.FailNow() }
+	if files, _ := os.ReadDir(dir); len(files) != 0 { t.FailNow() }
+}
+
+func TestSpillingChannelRejectsMetaSends(t *testing.T) {
+	encode := func(i int) []byte { return []byte(strconv.Itoa(i)) }
+	decode := func(data []byte) int { i, _ := strconv.Atoi(string(data)); return i }
+	tx, _ := NewSpillingChannel(4, encode, decode, t.TempDir())
+	defer func() {
+		if recover() == nil { t.FailNow() }
+	}()
+	tx.SendTrackedBounded(1, 1)
+}
+
+func TestSpillingChannelSegments(t *testing.T) {
+	defer func(size int64) { spill_segment_size = size }(spill_segment_size)
+	spill_segment_size = 16
+
+	dir := t.TempDir()
+	encode := func(i int) []byte { return []byte(strconv.Itoa(i)) }
+	decode := func(data []byte) int { i, _ := strconv.Atoi(string(data)); return i }
+	tx, rx := NewSpillingChannel(1, encode, decode, dir)
+	for i := 0; i < 100; i++ {
+		tx.Send(i)
+	}
+	files, _ := os.ReadDir(dir)
+	before := len(files)
+	for i := 0; i < 90; i++ {
+		msg, ok := rx.Recv(); if !ok || msg != i { t.FailNow() }
+	}
+	if files, _ := os.ReadDir(dir); len(files) >= before || len(files) > 4 { t.FailNow() }
+	tx.Close()
+	for i := 90; i < 100; i++ {
+		msg, ok := rx.Recv(); if !ok || msg != i { t.FailNow() }
+	}
+}
+
+func TestSpillingChannelReadError(t *testing.T) {
+	dir := t.TempDir()
+	encode := func(i int) []byte { return []byte(strconv.Itoa(i)) }
+	decode := func(data []byte) int { i, _ := strconv.Atoi(string(data)); return i }
+	tx, rx := NewSpillingChannel(2, encode, decode, dir)
+	for i := 0; i < 10; i++ {
+		tx.Send(i)
+	}
+	files, _ := os.ReadDir(dir)
+	if len(files) != 1 { t.FailNow() }
+	os.Truncate(filepath.Join(dir, files[0].Name()), 0)
+
+	msg, ok := rx.Recv(); if !ok || msg != 0 { t.FailNow() }
+	msg, ok = rx.Recv(); if !ok || msg != 1 { t.FailNow() }
+	_, ok = rx.Recv(); if ok { t.FailNow() }
+	if rx.Err() == nil { t.FailNow() }
+	if rx.Len() != 0 { t.FailNow() }
+	tx.Send(10)
+	if rx.Len() != 0 { t.FailNow() }
+}
+
+func TestSpillingChannelCapacity(t *testing.T) {
+	defer func() {
+		if recover() == nil { t.FailNow() }
+	}()
+	NewSpillingChannel(0, func(int) []byte { return nil }, func([]byte) int { return 0 }, t.TempDir())
+}
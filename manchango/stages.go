@@ -0,0 +1,388 @@
+package manchan
+
+import (
+	"fmt"
+	"slices"
+	"sync/atomic"
+	"time"
+)
+
+// StageBuffer is how many messages a combinator's output buffers before the
+// combinator blocks, so that a slow consumer pushes back on the producer
+// instead of letting memory grow. The Buffered variants take their own size.
+const StageBuffer = 16
+
+var n_stages atomic.Int64
+
+// go_stage runs a combinator's goroutine, keeping count for LeakCheck.
+func go_stage(f func()) {
+	n_stages.Add(1)
+	go func() {
+		defer n_stages.Add(-1)
+		f()
+	}()
+}
+
+// LeakCheck records how many combinator goroutines are running and returns a
+// function that reports an error if more than that are still running, after
+// giving them up to a second to exit. A combinator exits once its source
+// closes or once every receiver of its output has closed. It owns the
+// receivers it is given and closes them as it exits, so closing the output at
+// the end of a pipeline stops every combinator along it.
+func LeakCheck() func() error {
+	before := n_stages.Load()
+	return func() error {
+		deadline := time.Now().Add(time.Second)
+		for {
+			running := n_stages.Load()
+			if running <= before {
+				return nil
+			}
+			if time.Now().After(deadline) {
+				return fmt.Errorf("manchan: %d combinator goroutines leaked", running-before)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+}
+
+// MergeFair merges receivers into one, taking one message from each open
+// source in turn. Closed sources drop out of the rotation and the output
+// closes once every source has closed.
+//
+// Strict rotation means a fast source can never starve a slow one, but the
+// price is latency: while MergeFair waits on a quiet source, messages already
+// buffered on the others wait too. A greedy merge that forwards whatever is
+// ready would not hold them back, at the cost of fairness.
+func MergeFair[T any](receivers ...*Receiver[T]) *Receiver[T] {
+	tx, rx := NewBoundedChannel[T](StageBuffer)
+	sources := append([]*Receiver[T](nil), receivers...)
+	go_stage(func() {
+		defer tx.Close()
+		defer func() {
+			for _, source := range receivers {
+				source.Close()
+			}
+		}()
+		for len(sources) > 0 {
+			open := sources[:0]
+			for _, source := range sources {
+				msg, ok, abandoned := source.RecvCancel(tx.abandoned())
+				if abandoned {
+					return
+				}
+				if !ok {
+					continue
+				}
+				tx.Send(msg)
+				open = append(open, source)
+			}
+			sources = open
+		}
+	})
+	return rx
+}
+
+// MapStage applies a function to every message from a source. The function
+// can be swapped while the stage runs; see SetFunc.
+type MapStage[T, U any] struct {
+	f atomic.Pointer[func(T) U]
+}
+
+// NewMapStage starts a stage sending f(msg) for every msg received from rx.
+// The output closes once rx closes.
+func NewMapStage[T, U any](rx *Receiver[T], f func(T) U) (*MapStage[T, U], *Receiver[U]) {
+	stage := &MapStage[T, U]{}
+	stage.f.Store(&f)
+	tx_out, rx_out := NewBoundedChannel[U](StageBuffer)
+	go_stage(func() {
+		defer tx_out.Close()
+		defer rx.Close()
+		for {
+			msg, ok, abandoned := rx.RecvCancel(tx_out.abandoned())
+			if !ok || abandoned {
+				return
+			}
+			tx_out.Send((*stage.f.Load())(msg))
+		}
+	})
+	return stage, rx_out
+}
+
+// SetFunc replaces the function applied to messages received from now on.
+func (me *MapStage[T, U]) SetFunc(f func(T) U) {
+	me.f.Store(&f)
+}
+
+// dedup_window is how many recent IDs DedupByID remembers.
+const dedup_window = 4096
+
+// DedupByID forwards messages from rx, dropping any whose id matches one of
+// the last dedup_window IDs forwarded. Paired with at-least-once delivery
+// this approximates effectively-once processing, as long as duplicates
+// arrive within the window.
+func DedupByID[T any](rx *Receiver[T], id func(T) string) *Receiver[T] {
+	tx_out, rx_out := NewBoundedChannel[T](StageBuffer)
+	go_stage(func() {
+		defer tx_out.Close()
+		defer rx.Close()
+		seen := map[string]struct{}{}
+		order := make([]string, 0, dedup_window)
+		next := 0
+		for {
+			msg, ok, abandoned := rx.RecvCancel(tx_out.abandoned())
+			if !ok || abandoned {
+				return
+			}
+			key := id(msg)
+			if _, dup := seen[key]; dup {
+				continue
+			}
+			if len(order) < dedup_window {
+				order = append(order, key)
+			} else {
+				delete(seen, order[next])
+				order[next] = key
+				next = (next + 1) % dedup_window
+			}
+			seen[key] = struct{}{}
+			tx_out.Send(msg)
+		}
+	})
+	return rx_out
+}
+
+// Map sends f(msg) for every msg received from rx, closing its output once rx
+// closes. The output buffers StageBuffer messages; see MapBuffered.
+func Map[T, U any](rx *Receiver[T], f func(T) U) *Receiver[U] {
+	return MapBuffered(rx, f, StageBuffer)
+}
+
+// MapBuffered is Map with an output buffer of bufSize messages. Once that
+// fills, Map stops receiving from rx, so backpressure reaches rx's senders
+// if rx is bounded too. bufSize must be positive.
+func MapBuffered[T, U any](rx *Receiver[T], f func(T) U, bufSize int) *Receiver[U] {
+	tx_out, rx_out := NewBoundedChannel[U](bufSize)
+	go_stage(func() {
+		defer tx_out.Close()
+		defer rx.Close()
+		for {
+			msg, ok, abandoned := rx.RecvCancel(tx_out.abandoned())
+			if !ok || abandoned {
+				return
+			}
+			tx_out.Send(f(msg))
+		}
+	})
+	return rx_out
+}
+
+// Filter forwards the messages from rx for which keep returns true, closing
+// its output once rx closes. The output buffers StageBuffer messages; see
+// FilterBuffered.
+func Filter[T any](rx *Receiver[T], keep func(T) bool) *Receiver[T] {
+	return FilterBuffered(rx, keep, StageBuffer)
+}
+
+// FilterBuffered is Filter with an output buffer of bufSize messages, which
+// must be positive.
+func FilterBuffered[T any](rx *Receiver[T], keep func(T) bool, bufSize int) *Receiver[T] {
+	tx_out, rx_out := NewBoundedChannel[T](bufSize)
+	go_stage(func() {
+		defer tx_out.Close()
+		defer rx.Close()
+		for {
+			msg, ok, abandoned := rx.RecvCancel(tx_out.abandoned())
+			if !ok || abandoned {
+				return
+			}
+			if keep(msg) {
+				tx_out.Send(msg)
+			}
+		}
+	})
+	return rx_out
+}
+
+// TeeBuffered copies every message from rx to one output per entry in
+// bufSizes, each buffering up to its own size. A message goes first to every
+// output with room and only then waits on the full ones, so a slow consumer
+// holds back the others only once its own buffer has filled. An output whose
+// receivers have all closed is skipped, and the tee exits once rx closes or
+// every output is abandoned. Every size must be positive.
+func TeeBuffered[T any](rx *Receiver[T], bufSizes ...int) []*Receiver[T] {
+	txs := make([]*Sender[T], len(bufSizes))
+	rxs := make([]*Receiver[T], len(bufSizes))
+	for i, size := range bufSizes {
+		txs[i], rxs[i] = NewBoundedChannel[T](size)
+	}
+	all_abandoned := make(chan struct{})
+	finished := make(chan struct{})
+	go_stage(func() {
+		for _, tx := range txs {
+			select {
+			case <-tx.abandoned():
+			case <-finished:
+				return
+			}
+		}
+		close(all_abandoned)
+	})
+	go_stage(func() {
+		defer close(finished)
+		defer rx.Close()
+		defer func() {
+			for _, tx := range txs {
+				tx.Close()
+			}
+		}()
+		var full []*Sender[T]
+		for {
+			msg, ok, abandoned := rx.RecvCancel(all_abandoned)
+			if !ok || abandoned {
+				return
+			}
+			full = full[:0]
+			for _, tx := range txs {
+				if tx.send(msg, false) == ErrFull {
+					full = append(full, tx)
+				}
+			}
+			for _, tx := range full {
+				tx.Send(msg)
+			}
+		}
+	})
+	return rxs
+}
+
+// Labeled is a message tagged with the label of the source it came from.
+type Labeled[L comparable, T any] struct {
+	Label L
+	Value T
+}
+
+// MergeLabeled merges the receivers in sources into one, tagging each message
+// with the label its source is keyed by. Messages are forwarded as they
+// arrive, and the output closes once every source has closed.
+func MergeLabeled[L comparable, T any](sources map[L]*Receiver[T]) *Receiver[Labeled[L, T]] {
+	tx, rx := NewBoundedChannel[Labeled[L, T]](StageBuffer)
+	for label, source := range sources {
+		tx_source := tx.Clone()
+		go_stage(func() {
+			defer tx_source.Close()
+			defer source.Close()
+			for {
+				msg, ok, abandoned := source.RecvCancel(tx_source.abandoned())
+				if !ok || abandoned {
+					return
+				}
+				tx_source.Send(Labeled[L, T]{Label: label, Value: msg})
+			}
+		})
+	}
+	tx.Close()
+	return rx
+}
+
+// Tap passes every message from rx through to the main output and copies
+// about fraction of them to the sample output, for inspecting a stream
+// without duplicating all of it. Sampling is deterministic: every message
+// adds fraction to a running total, and one is copied each time the total
+// reaches a whole number. Both outputs push back like any combinator's, so
+// the sample must be drained too. Tap exits once rx closes or the main
+// output is abandoned.
+func Tap[T any](rx *Receiver[T], fraction float64) (*Receiver[T], *Receiver[T]) {
+	tx_main, rx_main := NewBoundedChannel[T](StageBuffer)
+	tx_sample, rx_sample := NewBoundedChannel[T](StageBuffer)
+	go_stage(func() {
+		defer tx_main.Close()
+		defer tx_sample.Close()
+		defer rx.Close()
+		total := 0.0
+		for {
+			msg, ok, abandoned := rx.RecvCancel(tx_main.abandoned())
+			if !ok || abandoned {
+				return
+			}
+			tx_main.Send(msg)
+			total += fraction
+			if total >= 1 {
+				total -= 1
+				tx_sample.Send(msg)
+			}
+		}
+	})
+	return rx_main, rx_sample
+}
+
+// Reduce folds every message from rx into an accumulator, starting from init,
+// and returns the result once rx closes. It runs on the caller's goroutine.
+func Reduce[T, A any](rx *Receiver[T], init A, f func(A, T) A) A {
+	acc := init
+	for msg, ok := rx.Recv(); ok; msg, ok = rx.Recv() {
+		acc = f(acc, msg)
+	}
+	return acc
+}
+
+// Chain passes rx through each stage in order, feeding every stage the
+// output of the one before, and returns the last output. With no stages it
+// returns rx. Since each combinator closes its input as it exits, closing the
+// last output stops every stage in the chain.
+func Chain[T any](rx *Receiver[T], stages ...func(*Receiver[T]) *Receiver[T]) *Receiver[T] {
+	for _, stage := range stages {
+		rx = stage(rx)
+	}
+	return rx
+}
+
+// Route forwards each message from rx to the sender in routes keyed by
+// key(msg), or to defaultTx if there is none; with a nil defaultTx unmatched
+// messages are dropped. It runs in its own goroutine and closes every sender
+// in routes, and defaultTx, once rx closes, each once even if it appears more
+// than once. It stops early once every one of those senders' receivers has
+// closed.
+func Route[K comparable, T any](rx *Receiver[T], key func(T) K, routes map[K]*Sender[T], defaultTx *Sender[T]) {
+	var outputs []*Sender[T]
+	for _, tx := range routes {
+		if !slices.Contains(outputs, tx) {
+			outputs = append(outputs, tx)
+		}
+	}
+	if defaultTx != nil && !slices.Contains(outputs, defaultTx) {
+		outputs = append(outputs, defaultTx)
+	}
+	all_abandoned := make(chan struct{})
+	finished := make(chan struct{})
+	go_stage(func() {
+		for _, tx := range outputs {
+			select {
+			case <-tx.abandoned():
+			case <-finished:
+				return
+			}
+		}
+		close(all_abandoned)
+	})
+	go_stage(func() {
+		defer close(finished)
+		defer rx.Close()
+		defer func() {
+			for _, tx := range outputs {
+				tx.Close()
+			}
+		}()
+		for {
+			msg, ok, abandoned := rx.RecvCancel(all_abandoned)
+			if !ok || abandoned {
+				return
+			}
+			if tx, found := routes[key(msg)]; found {
+				tx.Send(msg)
+			} else if defaultTx != nil {
+				defaultTx.Send(msg)
+			}
+		}
+	})
+}
@@ -0,0 +1,318 @@
+package manchan
+
+import (
+	"fmt"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMergeFair(t *testing.T) {
+	fastTx, fastRx := NewChannel[string]()
+	slowTx, slowRx := NewChannel[string]()
+	for i := 0; i < 6; i++ {
+		fastTx.Send("fast")
+	}
+	fastTx.Close()
+	go func() {
+		for i := 0; i < 3; i++ {
+			time.Sleep(5 * time.Millisecond)
+			slowTx.Send("slow")
+		}
+		slowTx.Close()
+	}()
+
+	results := []string{}
+	merged := MergeFair(fastRx, slowRx)
+	for msg, ok := merged.Recv(); ok; msg, ok = merged.Recv() {
+		results = append(results, msg)
+	}
+	if !reflect.DeepEqual(
+		results,
+		[]string{"fast", "slow", "fast", "slow", "fast", "slow", "fast", "fast", "fast"},
+	) { t.FailNow() }
+}
+
+func TestMapStage(t *testing.T) {
+	tx, rx := NewChannel[int]()
+	stage, out := NewMapStage(rx, func(i int) int { return i * 10 })
+
+	tx.Send(1)
+	msg, ok := out.Recv(); if !ok || msg != 10 { t.FailNow() }
+
+	stage.SetFunc(func(i int) int { return -i })
+	tx.Send(2)
+	tx.Close()
+	msg, ok = out.Recv(); if !ok || msg != -2 { t.FailNow() }
+	_, ok = out.Recv(); if ok { t.FailNow() }
+}
+
+func TestLeakCheckAbandonedStage(t *testing.T) {
+	check := LeakCheck()
+	tx, rx := NewChannel[int]()
+	_, out := NewMapStage(rx, func(i int) int { return i })
+	tx.Send(1)
+	msg, ok := out.Recv(); if !ok || msg != 1 { t.FailNow() }
+
+	out.Close()
+	if err := check(); err != nil { t.Fatal(err) }
+
+	tx.Send(2)
+	tx.Close()
+}
+
+func TestLeakCheckAbandonedPipeline(t *testing.T) {
+	check := LeakCheck()
+	tx, rx := NewChannel[int]()
+	out := Filter(Map(rx, func(i int) int { return i * 2 }), func(i int) bool { return i > 0 })
+	tx.Send(1)
+	msg, ok := out.Recv(); if !ok || msg != 2 { t.FailNow() }
+
+	out.Close()
+	if err := check(); err != nil { t.Fatal(err) }
+	<-tx.abandoned()
+	tx.Close()
+}
+
+func TestLeakCheckReportsLeak(t *testing.T) {
+	check := LeakCheck()
+	tx, rx := NewChannel[int]()
+	_, out := NewMapStage(rx, func(i int) int { return i })
+	if err := check(); err == nil { t.FailNow() }
+
+	tx.Close()
+	_, ok := out.Recv(); if ok { t.FailNow() }
+}
+
+func TestDedupByID(t *testing.T) {
+	tx, rx := NewChannel[string]()
+	for _, msg := range []string{"a", "b", "a", "c", "b"} {
+		tx.Send(msg)
+	}
+	tx.Close()
+
+	results := []string{}
+	out := DedupByID(rx, func(s string) string { return s })
+	for msg, ok := out.Recv(); ok; msg, ok = out.Recv() {
+		results = append(results, msg)
+	}
+	if !reflect.DeepEqual(results, []string{"a", "b", "c"}) { t.FailNow() }
+}
+
+func TestDedupByIDWindow(t *testing.T) {
+	tx, rx := NewChannel[int]()
+	for i := 0; i <= dedup_window; i++ {
+		tx.Send(i)
+	}
+	tx.Send(0)
+	tx.Send(dedup_window)
+	tx.Close()
+
+	count := 0
+	out := DedupByID(rx, func(i int) string { return fmt.Sprint(i) })
+	for _, ok := out.Recv(); ok; _, ok = out.Recv() {
+		count++
+	}
+	if count != dedup_window+2 { t.FailNow() }
+}
+
+func TestMapFilter(t *testing.T) {
+	tx, rx := NewChannel[int]()
+	for i := 0; i < 10; i++ {
+		tx.Send(i)
+	}
+	tx.Close()
+
+	results := []int{}
+	out := Map(Filter(rx, func(i int) bool { return i%3 == 0 }), func(i int) int { return i * 2 })
+	for msg, ok := out.Recv(); ok; msg, ok = out.Recv() {
+		results = append(results, msg)
+	}
+	if !reflect.DeepEqual(results, []int{0, 6, 12, 18}) { t.FailNow() }
+}
+
+func TestMapBufferedBackpressure(t *testing.T) {
+	tx, rx := NewBoundedChannel[int](4)
+	out := MapBuffered(rx, func(i int) int { return i }, 4)
+
+	sent := atomic.Int64{}
+	go func() {
+		for i := 0; i < 100; i++ {
+			tx.Send(i)
+			sent.Add(1)
+		}
+		tx.Close()
+	}()
+
+	for rx.ProducersBlocked() != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	if sent.Load() > 4+1+4 { t.FailNow() }
+	if out.Health(time.Second).Backlog > 4 { t.FailNow() }
+
+	for i := 0; i < 100; i++ {
+		msg, ok := out.Recv(); if !ok || msg != i { t.FailNow() }
+		if out.Health(time.Second).Backlog > 4 { t.FailNow() }
+	}
+	_, ok := out.Recv(); if ok { t.FailNow() }
+}
+
+func TestTeeBuffered(t *testing.T) {
+	tx, rx := NewChannel[int]()
+	outs := TeeBuffered(rx, 2, 8)
+	slow, fast := outs[0], outs[1]
+	for i := 0; i < 3; i++ { tx.Send(i) }
+
+	// The fast consumer gets all three while the slow one, holding two, has
+	// not received anything.
+	for i := 0; i < 3; i++ {
+		msg, ok := fast.Recv(); if !ok || msg != i { t.FailNow() }
+	}
+	// Now the slow consumer's buffer is full and holds back the fast one.
+	tx.Send(3)
+	time.Sleep(20 * time.Millisecond)
+	if fast.Len() != 0 { t.FailNow() }
+
+	tx.Close()
+	for i := 0; i < 4; i++ {
+		msg, ok := slow.Recv(); if !ok || msg != i { t.FailNow() }
+	}
+	msg, ok := fast.Recv(); if !ok || msg != 3 { t.FailNow() }
+	_, ok = fast.Recv(); if ok { t.FailNow() }
+	_, ok = slow.Recv(); if ok { t.FailNow() }
+}
+
+func TestBufferedStagesRejectZeroSize(t *testing.T) {
+	_, rx := NewChannel[int]()
+	for _, start := range []func(){
+		func() { MapBuffered(rx, func(i int) int { return i }, 0) },
+		func() { FilterBuffered(rx, func(int) bool { return true }, 0) },
+		func() { TeeBuffered(rx, 1, 0) },
+		func() { NewBoundedChannel[int](0) },
+		func() { NewByteBoundedChannel(0, func(int) int { return 1 }) },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil { t.FailNow() }
+			}()
+			start()
+		}()
+	}
+}
+
+func TestMergeLabeled(t *testing.T) {
+	txA, rxA := NewChannel[int]()
+	txB, rxB := NewChannel[int]()
+	merged := MergeLabeled(map[string]*Receiver[int]{"a": rxA, "b": rxB})
+	for i := 0; i < 3; i++ {
+		txA.Send(i)
+		txB.Send(10 + i)
+	}
+	txA.Close()
+	txB.Close()
+
+	got := map[string][]int{}
+	for msg, ok := merged.Recv(); ok; msg, ok = merged.Recv() {
+		got[msg.Label] = append(got[msg.Label], msg.Value)
+	}
+	if !reflect.DeepEqual(got, map[string][]int{"a": {0, 1, 2}, "b": {10, 11, 12}}) { t.FailNow() }
+}
+
+func TestTap(t *testing.T) {
+	tx, rx := NewChannel[int]()
+	main, sample := Tap(rx, 0.5)
+	for i := 0; i < 1000; i++ {
+		tx.Send(i)
+	}
+	tx.Close()
+
+	nSampled := make(chan int)
+	go func() {
+		n := 0
+		for _, ok := sample.Recv(); ok; _, ok = sample.Recv() {
+			n++
+		}
+		nSampled <- n
+	}()
+	for i := 0; i < 1000; i++ {
+		msg, ok := main.Recv(); if !ok || msg != i { t.FailNow() }
+	}
+	_, ok := main.Recv(); if ok { t.FailNow() }
+	if n := <-nSampled; n < 450 || n > 550 { t.FailNow() }
+}
+
+func TestReduce(t *testing.T) {
+	tx, rx := NewChannel[int]()
+	for i := 1; i <= 5; i++ {
+		tx.Send(i)
+	}
+	tx.Close()
+	sum := Reduce(rx, 0, func(acc int, msg int) int { return acc + msg })
+	if sum != 15 { t.FailNow() }
+}
+
+func TestChain(t *testing.T) {
+	tx, rx := NewChannel[int]()
+	for i := 0; i < 10; i++ {
+		tx.Send(i)
+	}
+	tx.Close()
+
+	out := Chain(rx,
+		func(rx *Receiver[int]) *Receiver[int] { return Filter(rx, func(i int) bool { return i%2 == 0 }) },
+		func(rx *Receiver[int]) *Receiver[int] { return Map(rx, func(i int) int { return i + 100 }) },
+	)
+	results := []int{}
+	for msg, ok := out.Recv(); ok; msg, ok = out.Recv() {
+		results = append(results, msg)
+	}
+	if !reflect.DeepEqual(results, []int{100, 102, 104, 106, 108}) { t.FailNow() }
+}
+
+func TestChainAbandoned(t *testing.T) {
+	check := LeakCheck()
+	tx, rx := NewChannel[int]()
+	double := func(rx *Receiver[int]) *Receiver[int] { return Map(rx, func(i int) int { return i * 2 }) }
+	out := Chain(rx, double, double)
+	tx.Send(1)
+	msg, ok := out.Recv(); if !ok || msg != 4 { t.FailNow() }
+
+	out.Close()
+	if err := check(); err != nil { t.Fatal(err) }
+	tx.Close()
+}
+
+func TestRoute(t *testing.T) {
+	tx, rx := NewChannel[string]()
+	tx_a, rx_a := NewChannel[string]()
+	tx_b, rx_b := NewChannel[string]()
+	tx_other, rx_other := NewChannel[string]()
+	Route(rx, func(msg string) string { return msg[:1] }, map[string]*Sender[string]{"a": tx_a, "b": tx_b}, tx_other)
+	for _, msg := range []string{"a1", "b1", "c1", "a2", "c2"} {
+		tx.Send(msg)
+	}
+	tx.Close()
+
+	collect := func(rx *Receiver[string]) []string {
+		results := []string{}
+		for msg, ok := rx.Recv(); ok; msg, ok = rx.Recv() {
+			results = append(results, msg)
+		}
+		return results
+	}
+	if !reflect.DeepEqual(collect(rx_a), []string{"a1", "a2"}) { t.FailNow() }
+	if !reflect.DeepEqual(collect(rx_b), []string{"b1"}) { t.FailNow() }
+	if !reflect.DeepEqual(collect(rx_other), []string{"c1", "c2"}) { t.FailNow() }
+}
+
+func TestRouteAbandoned(t *testing.T) {
+	check := LeakCheck()
+	tx, rx := NewChannel[string]()
+	tx_a, rx_a := NewChannel[string]()
+	Route(rx, func(msg string) string { return msg }, map[string]*Sender[string]{"a": tx_a, "b": tx_a}, tx_a)
+	tx.Send("a")
+	rx_a.Close()
+	if err := check(); err != nil { t.Fatal(err) }
+	tx.Close()
+}